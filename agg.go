@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tidwall/gjson"
+	"github.com/urfave/cli/v2"
+)
+
+// DefaultTermsSize is the number of buckets of --terms without a size.
+const DefaultTermsSize = 10
+
+// calendarIntervals are the date_histogram intervals sent as calendar_interval.
+var calendarIntervals = map[string]bool{
+	"minute": true, "1m": true,
+	"hour": true, "1h": true,
+	"day": true, "1d": true,
+	"week": true, "1w": true,
+	"month": true, "1M": true,
+	"quarter": true, "1q": true,
+	"year": true, "1y": true,
+}
+
+var aggCommand = &cli.Command{
+	Name:         "agg",
+	Usage:        "Aggregate the documents matching a query",
+	Action:       aggAction,
+	BashComplete: completeSearch,
+	Flags: append([]cli.Flag{
+		indexFlag,
+		outputFlag("table"),
+		columnsFlag,
+		&cli.StringSliceFlag{
+			Name:  "terms",
+			Usage: "Group by the top terms of a field as FIELD[:SIZE]. May be repeated to nest the groups",
+		},
+		&cli.StringFlag{
+			Name:  "date-histogram",
+			Usage: "Group by time as [FIELD:]INTERVAL, e.g. 1h or @timestamp:5m. The field defaults to the time field. Nests outside of --terms",
+		},
+		&cli.StringSliceFlag{
+			Name:  "cardinality",
+			Usage: "Count the distinct values of a field in every group. May be repeated",
+		},
+		&cli.StringSliceFlag{
+			Name:  "percentiles",
+			Usage: "Compute the percentiles of a field in every group as FIELD[:P1,P2...], e.g. bytes:50,95,99. May be repeated",
+		},
+		&cli.StringFlag{
+			Name:  "agg-file",
+			Usage: "Read the aggregations from a JSON file instead of the flags",
+		},
+	}, queryFlags()...),
+}
+
+func aggAction(c *cli.Context) error {
+	aggs, err := aggregations(c)
+	if err != nil {
+		return err
+	}
+	query, err := buildQuery(c)
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadAll(query)
+	if err != nil {
+		return err
+	}
+	inQuery := gjson.GetBytes(b, "aggs").Exists() || gjson.GetBytes(b, "aggregations").Exists()
+	values := map[string]interface{}{"size": 0, "track_total_hits": true}
+	switch {
+	case aggs != nil && inQuery:
+		return fmt.Errorf("the query of --filename already has aggregations")
+	case aggs != nil:
+		values["aggs"] = aggs
+	case !inQuery:
+		return fmt.Errorf("no aggregations; use --terms, --date-histogram, --cardinality, --percentiles or --agg-file")
+	}
+	body, err := mergeBody(b, values)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msgf("query: %s", body)
+	if c.Bool("render-only") {
+		fmt.Fprintf(c.App.Writer, "%s\n", body)
+		return nil
+	}
+
+	es, err := newClient(c)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := withSignals(context.Background())
+	defer cancel()
+
+	idx, err := indices(c)
+	if err != nil {
+		return err
+	}
+	if err := resolveIndices(ctx, es, idx); err != nil {
+		return err
+	}
+
+	res, err := es.Search(
+		es.Search.WithContext(ctx),
+		es.Search.WithIndex(idx...),
+		es.Search.WithBody(bytes.NewReader(body)),
+	)
+	if err != nil {
+		return fmt.Errorf("Error getting response: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return responseError(res)
+	}
+
+	b, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("Error parsing the response body: %s", err)
+	}
+	if !gjson.ValidBytes(b) {
+		return fmt.Errorf("Error parsing the response body: invalid JSON")
+	}
+
+	f, err := newFormatter(c.App.Writer, c.String("output"), c.StringSlice("columns"))
+	if err != nil {
+		return err
+	}
+	for _, row := range flattenAggs(gjson.ParseBytes(b)) {
+		doc, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		if err := f.Write(doc); err != nil {
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// aggregations returns the aggregations of --agg-file or of the aggregation
+// flags, or nil when none is given. The bucket aggregations of the flags are
+// nested in the order date histogram, then terms, and the metric
+// aggregations are computed in the innermost buckets.
+func aggregations(c *cli.Context) (json.RawMessage, error) {
+	flags := []string{"terms", "date-histogram", "cardinality", "percentiles"}
+	if name := c.String("agg-file"); name != "" {
+		for _, flag := range flags {
+			if c.IsSet(flag) {
+				return nil, fmt.Errorf("--agg-file cannot be combined with --%s", flag)
+			}
+		}
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		return aggsOf(name, b)
+	}
+
+	metrics := make(map[string]interface{})
+	for _, field := range c.StringSlice("cardinality") {
+		metrics["cardinality("+field+")"] = map[string]interface{}{
+			"cardinality": map[string]string{"field": field},
+		}
+	}
+	for _, v := range c.StringSlice("percentiles") {
+		field, percents, err := parsePercentiles(v)
+		if err != nil {
+			return nil, err
+		}
+		p := map[string]interface{}{"field": field}
+		if len(percents) > 0 {
+			p["percents"] = percents
+		}
+		metrics["percentiles("+field+")"] = map[string]interface{}{"percentiles": p}
+	}
+
+	type bucket struct {
+		name string
+		agg  map[string]interface{}
+	}
+	var buckets []bucket
+	if v := c.String("date-histogram"); v != "" {
+		tf, err := timeFilter(c, time.Time{}, time.Time{})
+		if err != nil {
+			return nil, err
+		}
+		field, interval := tf.Field, v
+		if i := strings.LastIndex(v, ":"); i >= 0 {
+			field, interval = v[:i], v[i+1:]
+		}
+		if field == "" || interval == "" {
+			return nil, fmt.Errorf("invalid --date-histogram %q, want [FIELD:]INTERVAL", v)
+		}
+		h := map[string]interface{}{"field": field, "min_doc_count": 0}
+		if calendarIntervals[interval] {
+			h["calendar_interval"] = interval
+		} else {
+			h["fixed_interval"] = interval
+		}
+		tz := c.String("timezone")
+		if tz == "Local" {
+			// A zone name keeps the buckets aligned across daylight saving
+			// time changes, unlike an offset.
+			if tz = localZoneName(os.LookupEnv, os.Readlink); tz == "" {
+				tz = time.Now().Format("-07:00")
+				log.Warn().Msgf("unknown name of the local time zone, the buckets use the offset %s and may shift at daylight saving time changes. Set --timezone to the zone name", tz)
+			}
+		}
+		h["time_zone"] = tz
+		buckets = append(buckets, bucket{field, map[string]interface{}{"date_histogram": h}})
+	}
+	for _, v := range c.StringSlice("terms") {
+		field, size, err := parseTerms(v)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, bucket{field, map[string]interface{}{
+			"terms": map[string]interface{}{"field": field, "size": size},
+		}})
+	}
+	if len(buckets) == 0 && len(metrics) == 0 {
+		return nil, nil
+	}
+
+	aggs := metrics
+	for i := len(buckets) - 1; i >= 0; i-- {
+		if len(aggs) > 0 {
+			buckets[i].agg["aggs"] = aggs
+		}
+		aggs = map[string]interface{}{buckets[i].name: buckets[i].agg}
+	}
+	return json.Marshal(aggs)
+}
+
+// aggsOf returns the aggregations of an --agg-file, either an aggregations
+// object or a search request body with an aggs or aggregations key.
+func aggsOf(name string, b []byte) (json.RawMessage, error) {
+	var v map[string]json.RawMessage
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, fmt.Errorf("aggregation file %s: invalid JSON: %s", name, err)
+	}
+	for _, k := range []string{"aggs", "aggregations"} {
+		if aggs, ok := v[k]; ok {
+			return aggs, nil
+		}
+	}
+	if len(v) == 0 {
+		return nil, fmt.Errorf("aggregation file %s: no aggregations", name)
+	}
+	return b, nil
+}
+
+// parseTerms parses FIELD[:SIZE].
+func parseTerms(v string) (string, int, error) {
+	field, size := v, DefaultTermsSize
+	if i := strings.LastIndex(v, ":"); i >= 0 {
+		n, err := strconv.Atoi(v[i+1:])
+		if err != nil || n < 1 {
+			return "", 0, fmt.Errorf("invalid --terms %q, want FIELD[:SIZE]", v)
+		}
+		field, size = v[:i], n
+	}
+	if field == "" {
+		return "", 0, fmt.Errorf("invalid --terms %q, want FIELD[:SIZE]", v)
+	}
+	return field, size, nil
+}
+
+// parsePercentiles parses FIELD[:P1,P2...].
+func parsePercentiles(v string) (string, []float64, error) {
+	field, list := v, ""
+	if i := strings.LastIndex(v, ":"); i >= 0 {
+		field, list = v[:i], v[i+1:]
+	}
+	if field == "" {
+		return "", nil, fmt.Errorf("invalid --percentiles %q, want FIELD[:P1,P2...]", v)
+	}
+	var percents []float64
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		p, err := strconv.ParseFloat(s, 64)
+		if err != nil || p < 0 || p > 100 {
+			return "", nil, fmt.Errorf("invalid percentile %q of --percentiles %q", s, v)
+		}
+		percents = append(percents, p)
+	}
+	return field, percents, nil
+}
+
+// aggCell is a column of an aggRow.
+type aggCell struct {
+	Name  string
+	Value json.RawMessage
+}
+
+// aggRow is a row of flattened aggregation results. It is marshaled as a
+// JSON object keeping the order of the columns.
+type aggRow []aggCell
+
+// MarshalJSON implements json.Marshaler.
+func (r aggRow) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, cell := range r {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, err := json.Marshal(cell.Name)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(name)
+		b.WriteByte(':')
+		b.Write(cell.Value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// with returns a copy of r with the cells appended.
+func (r aggRow) with(cells ...aggCell) aggRow {
+	row := make(aggRow, 0, len(r)+len(cells))
+	return append(append(row, r...), cells...)
+}
+
+// flattenAggs flattens the aggregations of the search response res into
+// one row per innermost bucket. A row holds the keys of the enclosing
+// buckets, the document count of its bucket as "count" and the values of the
+// metric aggregations. Multi-value metrics such as percentiles are split
+// into NAME.KEY columns.
+func flattenAggs(res gjson.Result) []aggRow {
+	total := res.Get("hits.total.value")
+	if !total.Exists() {
+		total = res.Get("hits.total")
+	}
+	var rows []aggRow
+	var walk func(level gjson.Result, prefix aggRow, count gjson.Result)
+	walk = func(level gjson.Result, prefix aggRow, count gjson.Result) {
+		type group struct {
+			name string
+			v    gjson.Result
+		}
+		var metrics aggRow
+		var groups []group
+		level.ForEach(func(k, v gjson.Result) bool {
+			name := k.String()
+			if !v.IsObject() || name == "meta" {
+				return true
+			}
+			switch {
+			case v.Get("buckets").Exists(), v.Get("doc_count").Exists():
+				groups = append(groups, group{name, v})
+			case v.Get("value").Exists():
+				value := v.Get("value_as_string")
+				if !value.Exists() {
+					value = v.Get("value")
+				}
+				metrics = append(metrics, aggCell{name, json.RawMessage(value.Raw)})
+			case v.Get("values").IsObject():
+				v.Get("values").ForEach(func(k, v gjson.Result) bool {
+					metrics = append(metrics, aggCell{name + "." + strings.TrimSuffix(k.String(), ".0"), json.RawMessage(v.Raw)})
+					return true
+				})
+			default:
+				v.ForEach(func(k, v gjson.Result) bool {
+					if !v.IsObject() && !v.IsArray() {
+						metrics = append(metrics, aggCell{name + "." + k.String(), json.RawMessage(v.Raw)})
+					}
+					return true
+				})
+			}
+			return true
+		})
+		if len(groups) == 0 {
+			row := prefix
+			if count.Exists() {
+				row = row.with(aggCell{"count", json.RawMessage(count.Raw)})
+			}
+			rows = append(rows, row.with(metrics...))
+			return
+		}
+		base := prefix.with(metrics...)
+		for _, g := range groups {
+			buckets := g.v.Get("buckets")
+			switch {
+			case !buckets.Exists():
+				walk(g.v, base, g.v.Get("doc_count"))
+			case buckets.IsArray():
+				buckets.ForEach(func(_, b gjson.Result) bool {
+					key := b.Get("key_as_string")
+					if !key.Exists() {
+						key = b.Get("key")
+					}
+					walk(b, base.with(aggCell{g.name, json.RawMessage(key.Raw)}), b.Get("doc_count"))
+					return true
+				})
+			default:
+				buckets.ForEach(func(k, b gjson.Result) bool {
+					walk(b, base.with(aggCell{g.name, json.RawMessage(k.Raw)}), b.Get("doc_count"))
+					return true
+				})
+			}
+		}
+	}
+	walk(res.Get("aggregations"), nil, total)
+	return rows
+}
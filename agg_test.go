@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+	"github.com/urfave/cli/v2"
+)
+
+func TestFlattenAggs(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{
+			in:   `{"hits":{"total":{"value":25}},"aggregations":{"cardinality(ip)":{"value":4}}}`,
+			want: []string{`{"count":25,"cardinality(ip)":4}`},
+		},
+		{
+			in:   `{"hits":{"total":{"value":3}},"aggregations":{"ip":{"buckets":[{"key":"192.0.2.1","doc_count":2},{"key":"192.0.2.2","doc_count":1}]}}}`,
+			want: []string{`{"ip":"192.0.2.1","count":2}`, `{"ip":"192.0.2.2","count":1}`},
+		},
+		{
+			in:   `{"hits":{"total":{"value":3}},"aggregations":{"@timestamp":{"buckets":[{"key_as_string":"2020-12-23T13:00:00Z","key":1608728400000,"doc_count":3,"ip":{"buckets":[{"key":"192.0.2.1","doc_count":3,"percentiles(bytes)":{"values":{"50.0":12.5,"99.9":100}}}]}}]}}}`,
+			want: []string{`{"@timestamp":"2020-12-23T13:00:00Z","ip":"192.0.2.1","count":3,"percentiles(bytes).50":12.5,"percentiles(bytes).99.9":100}`},
+		},
+		{
+			in:   `{"hits":{"total":{"value":3}},"aggregations":{"blocked":{"doc_count":2,"stats":{"count":2,"min":1,"max":3}}}}`,
+			want: []string{`{"count":2,"stats.count":2,"stats.min":1,"stats.max":3}`},
+		},
+		{
+			in:   `{"hits":{"total":3},"aggregations":{"f":{"buckets":{"errors":{"doc_count":1},"warnings":{"doc_count":2}}}}}`,
+			want: []string{`{"f":"errors","count":1}`, `{"f":"warnings","count":2}`},
+		},
+		{
+			in:   `{"hits":{"total":{"value":0}},"aggregations":{"ip":{"buckets":[]}}}`,
+			want: nil,
+		},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			var got []string
+			for _, row := range flattenAggs(gjson.Parse(tt.in)) {
+				b, err := json.Marshal(row)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, string(b))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("in: %v got: %v want: %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregations(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in      []string
+		want    string
+		wantErr bool
+	}{
+		{in: nil, want: "", wantErr: false},
+		{in: []string{"--terms", "ip:20"}, want: `{"ip":{"terms":{"field":"ip","size":20}}}`, wantErr: false},
+		{in: []string{"--cardinality", "ip"}, want: `{"cardinality(ip)":{"cardinality":{"field":"ip"}}}`, wantErr: false},
+		{in: []string{"--date-histogram", "1h", "--terms", "ip", "--percentiles", "bytes:50,99"}, want: `{"@timestamp":{"aggs":{"ip":{"aggs":{"percentiles(bytes)":{"percentiles":{"field":"bytes","percents":[50,99]}}},"terms":{"field":"ip","size":10}}},"date_histogram":{"calendar_interval":"1h","field":"@timestamp","min_doc_count":0,"time_zone":"UTC"}}}`, wantErr: false},
+		{in: []string{"--date-histogram", "event.created:5m"}, want: `{"event.created":{"date_histogram":{"field":"event.created","fixed_interval":"5m","min_doc_count":0,"time_zone":"UTC"}}}`, wantErr: false},
+		{in: []string{"--agg-file", "./testdata/aggs.json"}, want: `{"actions":{"terms":{"field":"action"}}}`, wantErr: false},
+		{in: []string{"--agg-file", "./testdata/aggs.json", "--terms", "ip"}, want: "", wantErr: true},
+		{in: []string{"--terms", "ip:x"}, want: "", wantErr: true},
+		{in: []string{"--percentiles", "bytes:101"}, want: "", wantErr: true},
+		{in: []string{"--date-histogram", "@timestamp:"}, want: "", wantErr: true},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			flags := []cli.Flag{
+				&cli.StringSliceFlag{Name: "terms"},
+				&cli.StringFlag{Name: "date-histogram"},
+				&cli.StringSliceFlag{Name: "cardinality"},
+				&cli.StringSliceFlag{Name: "percentiles"},
+				&cli.StringFlag{Name: "agg-file"},
+				&cli.StringFlag{Name: "timezone", Value: "UTC"},
+				&cli.StringFlag{Name: "time-field"},
+				&cli.StringFlag{Name: "time-format"},
+				&cli.BoolFlag{Name: "no-time-filter"},
+			}
+			set := flag.NewFlagSet("test", 0)
+			for _, fl := range flags {
+				_ = fl.Apply(set)
+			}
+			_ = set.Parse(tt.in)
+			c := cli.NewContext(nil, set, nil)
+			got, err := aggregations(c)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("in: %v err: %v wantErr: %v", tt.in, err, tt.wantErr)
+			}
+			if strings.Join(strings.Fields(string(got)), "") != tt.want {
+				t.Fatalf("in: %v got: %s want: %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Checkpoint wraps the progress of a resumable search export.
+type Checkpoint struct {
+	// Search identifies the search the checkpoint belongs to.
+	Search string `json:"search"`
+	// SearchAfter is the sort values of the last hit written.
+	SearchAfter json.RawMessage `json:"search_after,omitempty"`
+	Hits        int64           `json:"hits"`
+	Docs        int64           `json:"docs"`
+	// Offset is the size of the output file after the last page written.
+	Offset  int64    `json:"offset"`
+	Columns []string `json:"columns,omitempty"`
+	// Since and Until are the time range of the search, resolved when the
+	// export started.
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until"`
+}
+
+// searchFingerprint identifies a search by its parameters.
+func searchFingerprint(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		fmt.Fprintf(h, "%d:%s;", len(p), p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// readCheckpoint reads the checkpoint at path. A missing file results in a
+// nil checkpoint.
+func readCheckpoint(path string) (*Checkpoint, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return nil, fmt.Errorf("Error parsing the checkpoint %s: %s", path, err)
+	}
+	return &cp, nil
+}
+
+// loadCheckpoint reads the checkpoint at path. A missing file results in an
+// empty checkpoint for search.
+func loadCheckpoint(path, search string) (*Checkpoint, error) {
+	cp, err := readCheckpoint(path)
+	if err != nil {
+		return nil, err
+	}
+	if cp == nil {
+		return &Checkpoint{Search: search}, nil
+	}
+	if cp.Search != search {
+		return nil, fmt.Errorf("checkpoint %s belongs to another search; resuming requires the same index, query and output", path)
+	}
+	return cp, nil
+}
+
+// checkpointRange returns the time range recorded in the checkpoint at path,
+// or since and until when there is no checkpoint to resume.
+func checkpointRange(path string, since, until time.Time) (time.Time, time.Time, error) {
+	cp, err := readCheckpoint(path)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if cp == nil || cp.Since.IsZero() {
+		return since, until, nil
+	}
+	return cp.Since, cp.Until, nil
+}
+
+// Save writes cp to path atomically.
+func (cp *Checkpoint) Save(path string) error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// checkpointer records the progress of a search after every page.
+type checkpointer struct {
+	path string
+	cp   *Checkpoint
+	file *os.File
+	buf  *bufio.Writer
+	f    Resumable
+	pg   *pitPaginator
+	// closed is set once the output file is closed.
+	closed bool
+}
+
+// newCheckpointer resumes the export into the output file name from the
+// checkpoint at path, or starts a new one of the time range from since to
+// until.
+func newCheckpointer(path, name, format string, columns []string, search string, since, until time.Time) (*checkpointer, error) {
+	cp, err := loadCheckpoint(path, search)
+	if err != nil {
+		return nil, err
+	}
+	if cp.Since.IsZero() {
+		cp.Since, cp.Until = since, until
+	}
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// Drop whatever was written after the last checkpoint.
+	if err := file.Truncate(cp.Offset); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(cp.Offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	buf := bufio.NewWriter(file)
+	f, err := newFormatter(buf, format, columns)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	r, ok := f.(Resumable)
+	if !ok {
+		file.Close()
+		return nil, fmt.Errorf("--checkpoint does not support the %s output", format)
+	}
+	if cp.Offset > 0 {
+		r.Resume(cp.Columns)
+		log.Info().Msgf("resuming from %s after %d hits, searching %s to %s", path, cp.Hits, cp.Since.Format(time.RFC3339), cp.Until.Format(time.RFC3339))
+	}
+	return &checkpointer{path: path, cp: cp, file: file, buf: buf, f: r}, nil
+}
+
+// Resuming reports whether the export continues from a previous run.
+func (k *checkpointer) Resuming() bool {
+	return k.cp.Hits > 0
+}
+
+// Save flushes the output written for p and records the progress.
+func (k *checkpointer) Save(p *Page, docs int64) error {
+	if err := k.f.Flush(); err != nil {
+		return err
+	}
+	if err := k.buf.Flush(); err != nil {
+		return err
+	}
+	if err := k.file.Sync(); err != nil {
+		return err
+	}
+	offset, err := k.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	k.cp.SearchAfter = k.pg.after
+	k.cp.Hits += p.Hits
+	k.cp.Docs = docs
+	k.cp.Offset = offset
+	k.cp.Columns = k.f.Columns()
+	return k.cp.Save(k.path)
+}
+
+// Close closes the output file. The checkpoint is removed once the export
+// has completed. Closing again does nothing.
+func (k *checkpointer) Close(completed bool) error {
+	if k.closed {
+		return nil
+	}
+	k.closed = true
+	if err := k.buf.Flush(); err != nil {
+		k.file.Close()
+		return err
+	}
+	if err := k.file.Close(); err != nil {
+		return err
+	}
+	if !completed {
+		return nil
+	}
+	if err := os.Remove(k.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCheckpoint(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "escli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+	search := searchFingerprint("log-aws-waf-*", `{"query":{"match_all":{}}}`)
+
+	got, err := loadCheckpoint(path, search)
+	if err != nil {
+		t.Fatalf("in: %v err: %v", path, err)
+	}
+	if want := (&Checkpoint{Search: search}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("in: %v got: %v want: %v", path, got, want)
+	}
+
+	want := &Checkpoint{Search: search, SearchAfter: json.RawMessage(`[1608728645000,"a"]`), Hits: 10, Docs: 8, Offset: 120, Columns: []string{"_id"}}
+	if err := want.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = loadCheckpoint(path, search); err != nil {
+		t.Fatalf("in: %v err: %v", path, err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("in: %v got: %v want: %v", path, got, want)
+	}
+
+	if _, err := loadCheckpoint(path, searchFingerprint("log-aws-waf-*", `{}`)); err == nil {
+		t.Fatalf("in: %v err: %v wantErr: true", path, err)
+	}
+}
+
+func TestCheckpointRange(t *testing.T) {
+	t.Parallel()
+	tokyo := time.FixedZone("JST", 9*60*60)
+	since := time.Date(2020, 12, 23, 13, 4, 5, 0, tokyo)
+	until := time.Date(2020, 12, 23, 14, 15, 16, 123000000, tokyo)
+	now := time.Date(2020, 12, 24, 9, 0, 0, 0, tokyo)
+	tests := []struct {
+		in        *Checkpoint
+		wantSince time.Time
+		wantUntil time.Time
+	}{
+		{in: nil, wantSince: now.Add(-30 * time.Minute), wantUntil: now},
+		{in: &Checkpoint{Search: "a", Hits: 10, Since: since, Until: until}, wantSince: since, wantUntil: until},
+		// A checkpoint without a time range searches the range of the flags.
+		{in: &Checkpoint{Search: "a", Hits: 10}, wantSince: now.Add(-30 * time.Minute), wantUntil: now},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			dir, err := ioutil.TempDir("", "escli")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "checkpoint.json")
+			if tt.in != nil {
+				if err := tt.in.Save(path); err != nil {
+					t.Fatal(err)
+				}
+			}
+			gotSince, gotUntil, err := checkpointRange(path, now.Add(-30*time.Minute), now)
+			if err != nil {
+				t.Fatalf("in: %v err: %v", tt.in, err)
+			}
+			// The range is formatted into the query the checkpoint is bound to.
+			if got, want := gotSince.Format(time.RFC3339Nano), tt.wantSince.Format(time.RFC3339Nano); got != want {
+				t.Fatalf("in: %v got: %v want: %v", tt.in, got, want)
+			}
+			if got, want := gotUntil.Format(time.RFC3339Nano), tt.wantUntil.Format(time.RFC3339Nano); got != want {
+				t.Fatalf("in: %v got: %v want: %v", tt.in, got, want)
+			}
+		})
+	}
+}
@@ -1,32 +1,71 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
+	b64 "encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"os"
+	"strings"
 
 	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
 	"github.com/rs/zerolog/log"
 	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
 )
 
+// newClient returns the client of the cluster configured by the flags, the
+// environment variables and the current context in that order.
 func newClient(c *cli.Context) (*elasticsearch.Client, error) {
-	var err error
-	tp := http.DefaultTransport.(*http.Transport).Clone()
+	ctx, err := currentContext(c)
+	if err != nil {
+		return nil, err
+	}
 
-	if tp.TLSClientConfig.RootCAs, err = x509.SystemCertPool(); err != nil {
+	tp := http.DefaultTransport.(*http.Transport).Clone()
+	if err := configureTLS(tp, tlsOptions(c, ctx)); err != nil {
 		return nil, err
 	}
 
-	address := c.String("address")
-	username := c.String("username")
-	password := c.String("password")
+	addresses := ctx.Addresses
+	if c.IsSet("address") || len(addresses) == 0 {
+		addresses = []string{c.String("address")}
+	}
+	creds, err := credentials(c, ctx)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Msgf("context: %q addresses: %s auth: %s", ctx.Name, strings.Join(addresses, ","), creds.Kind())
 	cfg := elasticsearch.Config{
-		Addresses: []string{address},
-		Username:  username,
-		Password:  password,
+		Addresses: addresses,
+		Username:  creds.Username,
+		Password:  creds.Password,
 		Transport: tp,
 		Logger:    &CustomLogger{log.Logger},
 	}
+	if auth := creds.Authorization(); auth != "" {
+		cfg.Header = http.Header{"Authorization": []string{auth}}
+	}
+	if aws := awsOptions(c, ctx); aws.SigV4 {
+		if kind := creds.Kind(); kind != "none" {
+			return nil, fmt.Errorf("--aws-sigv4 cannot be combined with %s credentials", kind)
+		}
+		awsCreds, region, err := loadAWSCredentials(aws, os.Getenv)
+		if err != nil {
+			return nil, err
+		}
+		log.Debug().Msgf("aws: sigv4 region: %s service: %s", region, firstNonEmpty(aws.Service, DefaultAWSService))
+		cfg.Transport = newSigV4Transport(tp, awsCreds, region, aws.Service)
+	}
 	es, err := elasticsearch.NewClient(cfg)
 	if err != nil {
 		return nil, err
@@ -34,3 +73,309 @@ func newClient(c *cli.Context) (*elasticsearch.Client, error) {
 
 	return es, nil
 }
+
+// Credentials authenticate the client with one of basic authentication, an
+// API key, a service account token or a bearer token.
+type Credentials struct {
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// APIKey is an API key as id:key or in the encoded form.
+	APIKey       string `yaml:"api-key,omitempty"`
+	ServiceToken string `yaml:"service-token,omitempty"`
+	BearerToken  string `yaml:"bearer-token,omitempty"`
+}
+
+// credentialFlags are the flags of the credentials.
+var credentialFlags = []string{"username", "password", "password-file", "api-key", "service-token", "bearer-token"}
+
+// credentials returns the credentials of the flags and the environment
+// variables, or of the context when none is given. The password of a
+// username without one is read from stdin or a file, or prompted for on a
+// terminal.
+func credentials(c *cli.Context, ctx *Context) (Credentials, error) {
+	// A username, API key or token replaces the credentials of the context,
+	// a password alone completes them.
+	set := false
+	for _, name := range []string{"username", "api-key", "service-token", "bearer-token"} {
+		set = set || c.IsSet(name)
+	}
+	creds := ctx.Credentials
+	if set {
+		creds = Credentials{
+			Username:     c.String("username"),
+			APIKey:       c.String("api-key"),
+			ServiceToken: c.String("service-token"),
+			BearerToken:  c.String("bearer-token"),
+		}
+	}
+	if set || c.IsSet("password") {
+		creds.Password = c.String("password")
+	}
+
+	// The password sources given on the command line exclude each other and
+	// override ELASTICSEARCH_PASSWORD and ELASTICSEARCH_PASSWORD_FILE.
+	n := 0
+	for _, ok := range []bool{onCommandLine(c, "password"), c.Bool("password-stdin"), onCommandLine(c, "password-file")} {
+		if ok {
+			n++
+		}
+	}
+	if n > 1 {
+		return Credentials{}, fmt.Errorf("only one of --password, --password-stdin and --password-file may be set")
+	}
+	switch {
+	case onCommandLine(c, "password"):
+	case c.Bool("password-stdin"):
+		b, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return Credentials{}, fmt.Errorf("Error reading the password from stdin: %s", err)
+		}
+		creds.Password = strings.TrimRight(string(b), "\r\n")
+	case c.IsSet("password-file"):
+		b, err := ioutil.ReadFile(c.String("password-file"))
+		if err != nil {
+			return Credentials{}, fmt.Errorf("Error reading the password file: %s", err)
+		}
+		creds.Password = strings.TrimRight(string(b), "\r\n")
+	}
+
+	switch {
+	case creds.Username == "" && creds.Password != "":
+		return Credentials{}, fmt.Errorf("a password is given without a username, set --username")
+	case creds.Username != "" && creds.Password == "":
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return Credentials{}, fmt.Errorf("no password for the user %q, set --password, --password-stdin, --password-file or ELASTICSEARCH_PASSWORD", creds.Username)
+		}
+		fmt.Fprintf(os.Stderr, "Password for %s: ", creds.Username)
+		b, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return Credentials{}, fmt.Errorf("Error reading the password: %s", err)
+		}
+		if creds.Password = string(b); creds.Password == "" {
+			return Credentials{}, fmt.Errorf("no password for the user %q", creds.Username)
+		}
+	}
+	if err := creds.Validate(); err != nil {
+		return Credentials{}, err
+	}
+	return creds, nil
+}
+
+// onCommandLine reports whether the flag name is given on the command line.
+// Unlike c.IsSet, it is false for a flag set by its environment variables.
+func onCommandLine(c *cli.Context, name string) bool {
+	for _, ctx := range c.Lineage() {
+		for _, n := range ctx.LocalFlagNames() {
+			if n == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Kind returns the kind of the credentials.
+func (cr Credentials) Kind() string {
+	var kinds []string
+	if cr.Username != "" || cr.Password != "" {
+		kinds = append(kinds, "basic")
+	}
+	if cr.APIKey != "" {
+		kinds = append(kinds, "api-key")
+	}
+	if cr.ServiceToken != "" {
+		kinds = append(kinds, "service-token")
+	}
+	if cr.BearerToken != "" {
+		kinds = append(kinds, "bearer-token")
+	}
+	if len(kinds) == 0 {
+		return "none"
+	}
+	return strings.Join(kinds, ",")
+}
+
+// Validate checks that at most one kind of credentials is set.
+func (cr Credentials) Validate() error {
+	if kind := cr.Kind(); strings.Contains(kind, ",") {
+		return fmt.Errorf("only one of --username/--password, --api-key, --service-token and --bearer-token may be set, got %s", strings.ReplaceAll(kind, ",", " and "))
+	}
+	return nil
+}
+
+// Authorization returns the Authorization header of the API key and tokens,
+// or "" for basic authentication.
+func (cr Credentials) Authorization() string {
+	switch {
+	case cr.APIKey != "":
+		key := cr.APIKey
+		if strings.Contains(key, ":") {
+			key = b64.StdEncoding.EncodeToString([]byte(key))
+		}
+		return "ApiKey " + key
+	case cr.ServiceToken != "":
+		return "Bearer " + cr.ServiceToken
+	case cr.BearerToken != "":
+		return "Bearer " + cr.BearerToken
+	}
+	return ""
+}
+
+// tlsOptions returns the TLS configuration of the context with the settings
+// of the flags and the environment variables.
+func tlsOptions(c *cli.Context, ctx *Context) TLSConfig {
+	cfg := ctx.TLS
+	for name, v := range map[string]*string{
+		"cacert":         &cfg.CACert,
+		"cert":           &cfg.Cert,
+		"key":            &cfg.Key,
+		"ca-fingerprint": &cfg.CAFingerprint,
+	} {
+		if c.IsSet(name) {
+			*v = c.String(name)
+		}
+	}
+	if c.IsSet("insecure-skip-verify") {
+		cfg.InsecureSkipVerify = c.Bool("insecure-skip-verify")
+	}
+	return cfg
+}
+
+// configureTLS sets the TLS configuration of tp trusting the system
+// certificate authorities and those of cfg.
+func configureTLS(tp *http.Transport, cfg TLSConfig) error {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		log.Debug().Msgf("Error loading the system certificate pool: %s", err)
+		pool = x509.NewCertPool()
+	}
+	if cfg.CACert != "" {
+		pem, err := ioutil.ReadFile(cfg.CACert)
+		if err != nil {
+			return err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in %s", cfg.CACert)
+		}
+	}
+	tc := &tls.Config{RootCAs: pool}
+
+	if (cfg.Cert == "") != (cfg.Key == "") {
+		return fmt.Errorf("--cert and --key must be given together")
+	}
+	if cfg.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+		if err != nil {
+			return fmt.Errorf("Error loading the client certificate: %s", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	switch {
+	case cfg.InsecureSkipVerify && cfg.CAFingerprint != "":
+		return fmt.Errorf("--insecure-skip-verify cannot be combined with --ca-fingerprint")
+	case cfg.InsecureSkipVerify:
+		log.Warn().Msg("The certificate of the server is not verified")
+		tc.InsecureSkipVerify = true
+	case cfg.CAFingerprint != "":
+		fingerprint, err := parseFingerprint(cfg.CAFingerprint)
+		if err != nil {
+			return err
+		}
+		// The certificate with the fingerprint replaces the certificate
+		// authorities, the chain and the host name are still verified.
+		tc.InsecureSkipVerify = true
+		tc.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyPinned(cs.PeerCertificates, fingerprint, cs.ServerName)
+		}
+		// The server name of the connection state is empty for IP
+		// addresses, so the direct connections verify the dialed host.
+		tp.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			c := tc.Clone()
+			c.ServerName = host
+			c.VerifyConnection = func(cs tls.ConnectionState) error {
+				return verifyPinned(cs.PeerCertificates, fingerprint, host)
+			}
+			return (&tls.Dialer{Config: c}).DialContext(ctx, network, addr)
+		}
+	}
+	tp.TLSClientConfig = tc
+	return nil
+}
+
+// verifyPinned verifies that certs, the certificates presented by the server,
+// chain up to the certificate with fingerprint and are valid for host.
+func verifyPinned(certs []*x509.Certificate, fingerprint []byte, host string) error {
+	if len(certs) == 0 {
+		return fmt.Errorf("the server presented no certificate")
+	}
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	pinned := false
+	for _, cert := range certs {
+		if sum := sha256.Sum256(cert.Raw); bytes.Equal(sum[:], fingerprint) {
+			roots.AddCert(cert)
+			pinned = true
+		} else {
+			intermediates.AddCert(cert)
+		}
+	}
+	if !pinned {
+		return fmt.Errorf("no certificate of the server matches the fingerprint %X", fingerprint)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, DNSName: host})
+	return err
+}
+
+// parseFingerprint decodes a SHA-256 fingerprint in hex, optionally
+// separated by colons as printed by Elasticsearch and openssl.
+func parseFingerprint(s string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, ":", ""))
+	if err != nil || len(b) != sha256.Size {
+		return nil, fmt.Errorf("invalid --ca-fingerprint %q, want the SHA-256 fingerprint in hex", s)
+	}
+	return b, nil
+}
+
+// responseError converts an Elasticsearch error response into an error.
+func responseError(res *esapi.Response) error {
+	var e map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&e); err != nil {
+		return fmt.Errorf("Error parsing the response body: %s", err)
+	}
+	reason, ok := e["error"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("[%s] %v%s", res.Status(), e["error"], authHint(res))
+	}
+	// Print the response status and error information.
+	return fmt.Errorf("[%s] %s: %s%s",
+		res.Status(),
+		reason["type"],
+		reason["reason"],
+		authHint(res),
+	)
+}
+
+// authHint returns the authentication schemes the server accepts when it
+// rejected the credentials.
+func authHint(res *esapi.Response) string {
+	if res.StatusCode != http.StatusUnauthorized {
+		return ""
+	}
+	var schemes []string
+	for _, v := range res.Header.Values("WWW-Authenticate") {
+		for _, s := range strings.Split(v, ",") {
+			if f := strings.Fields(s); len(f) > 0 && !strings.Contains(f[0], "=") {
+				schemes = append(schemes, f[0])
+			}
+		}
+	}
+	if len(schemes) == 0 {
+		return "; check the credentials"
+	}
+	return fmt.Sprintf("; check the credentials, the server accepts %s", strings.Join(schemes, ", "))
+}
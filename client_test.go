@@ -0,0 +1,338 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/urfave/cli/v2"
+)
+
+func TestCredentials(t *testing.T) {
+	t.Parallel()
+	// The password flag of the cases with env reads ESCLI_TEST_PASSWORD like
+	// --password reads ELASTICSEARCH_PASSWORD.
+	os.Setenv("ESCLI_TEST_PASSWORD", "envpw")
+	t.Cleanup(func() { os.Unsetenv("ESCLI_TEST_PASSWORD") })
+	type in struct {
+		args []string
+		env  bool
+		ctx  Credentials
+	}
+	tests := []struct {
+		in      in
+		want    string
+		wantErr bool
+	}{
+		{in: in{args: []string{"--username", "elastic", "--password", "changeme"}}, want: "elastic:changeme", wantErr: false},
+		{in: in{args: []string{"--api-key", "id:key"}}, want: "ApiKey aWQ6a2V5", wantErr: false},
+		{in: in{args: []string{"--api-key", "aWQ6a2V5"}}, want: "ApiKey aWQ6a2V5", wantErr: false},
+		{in: in{args: []string{"--service-token", "AAEAAWVsYXN0aWM"}}, want: "Bearer AAEAAWVsYXN0aWM", wantErr: false},
+		{in: in{args: []string{"--bearer-token", "eyJhbGciOi"}}, want: "Bearer eyJhbGciOi", wantErr: false},
+		{in: in{ctx: Credentials{APIKey: "id:key"}}, want: "ApiKey aWQ6a2V5", wantErr: false},
+		{in: in{args: []string{"--bearer-token", "eyJhbGciOi"}, ctx: Credentials{APIKey: "id:key"}}, want: "Bearer eyJhbGciOi", wantErr: false},
+		{in: in{args: []string{"--username", "elastic", "--password", "changeme"}, ctx: Credentials{APIKey: "id:key"}}, want: "elastic:changeme", wantErr: false},
+		{in: in{args: []string{"--password", "changeme"}, ctx: Credentials{Username: "elastic"}}, want: "elastic:changeme", wantErr: false},
+		{in: in{args: []string{"--username", "elastic", "--password-file", "testdata/password"}}, want: "elastic:changeme", wantErr: false},
+		{in: in{args: []string{"--username", "elastic"}, env: true}, want: "elastic:envpw", wantErr: false},
+		{in: in{args: []string{"--username", "elastic", "--password-file", "testdata/password"}, env: true}, want: "elastic:changeme", wantErr: false},
+		{in: in{args: []string{"--username", "elastic", "--password", "clipw"}, env: true}, want: "elastic:clipw", wantErr: false},
+		{in: in{ctx: Credentials{}}, want: "none", wantErr: false},
+		{in: in{args: []string{"--username", "elastic"}}, want: "", wantErr: true},
+		{in: in{ctx: Credentials{Username: "elastic"}}, want: "", wantErr: true},
+		{in: in{args: []string{"--password", "changeme"}}, want: "", wantErr: true},
+		{in: in{args: []string{"--username", "elastic", "--password", "changeme", "--password-file", "testdata/password"}}, want: "", wantErr: true},
+		{in: in{args: []string{"--username", "elastic", "--password-file", "testdata/nonexistent"}}, want: "", wantErr: true},
+		{in: in{args: []string{"--api-key", "id:key", "--username", "elastic"}}, want: "", wantErr: true},
+		{in: in{args: []string{"--api-key", "id:key", "--service-token", "AAEAAWVsYXN0aWM"}}, want: "", wantErr: true},
+		{in: in{ctx: Credentials{Username: "elastic", BearerToken: "eyJhbGciOi"}}, want: "", wantErr: true},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			var flags []cli.Flag
+			for _, name := range credentialFlags {
+				f := &cli.StringFlag{Name: name}
+				if name == "password" && tt.in.env {
+					f.EnvVars = []string{"ESCLI_TEST_PASSWORD"}
+				}
+				flags = append(flags, f)
+			}
+			set := flag.NewFlagSet("test", 0)
+			for _, f := range flags {
+				_ = f.Apply(set)
+			}
+			if err := set.Parse(tt.in.args); err != nil {
+				t.Fatal(err)
+			}
+			c := cli.NewContext(&cli.App{Flags: flags}, set, nil)
+			creds, err := credentials(c, &Context{Credentials: tt.in.ctx})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("in: %v err: %v wantErr: %v", tt.in, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got := creds.Authorization()
+			if creds.Kind() == "basic" {
+				got = creds.Username + ":" + creds.Password
+			} else if got == "" {
+				got = creds.Kind()
+			}
+			if got != tt.want {
+				t.Fatalf("in: %v got: %v want: %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseError(t *testing.T) {
+	t.Parallel()
+	type in struct {
+		status int
+		header http.Header
+		body   string
+	}
+	tests := []struct {
+		in   in
+		want string
+	}{
+		{
+			in:   in{status: 404, body: `{"error":{"type":"index_not_found_exception","reason":"no such index [waf]"},"status":404}`},
+			want: "[404 Not Found] index_not_found_exception: no such index [waf]",
+		},
+		{
+			in: in{
+				status: 401,
+				header: http.Header{"Www-Authenticate": []string{`Basic realm="security" charset="UTF-8"`, `ApiKey`}},
+				body:   `{"error":{"type":"security_exception","reason":"unable to authenticate with provided credentials and anonymous access is not allowed for this request"},"status":401}`,
+			},
+			want: "[401 Unauthorized] security_exception: unable to authenticate with provided credentials and anonymous access is not allowed for this request; check the credentials, the server accepts Basic, ApiKey",
+		},
+		{
+			in:   in{status: 401, body: `{"error":"Unauthorized"}`},
+			want: "[401 Unauthorized] Unauthorized; check the credentials",
+		},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			res := &esapi.Response{StatusCode: tt.in.status, Header: tt.in.header, Body: ioutil.NopCloser(strings.NewReader(tt.in.body))}
+			if got := responseError(res).Error(); got != tt.want {
+				t.Fatalf("in: %v got: %v want: %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	clientCert, clientKey := writeClientCert(t, dir)
+	pool := x509.NewCertPool()
+	b, err := ioutil.ReadFile(clientCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.AppendCertsFromPEM(b)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	caCert := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(srv.Certificate().Raw)
+	fingerprint := hex.EncodeToString(sum[:])
+	var colons []string
+	for _, c := range sum {
+		colons = append(colons, fmt.Sprintf("%02X", c))
+	}
+
+	tests := []struct {
+		in         TLSConfig
+		wantErr    bool
+		wantReqErr bool
+	}{
+		{in: TLSConfig{CACert: caCert, Cert: clientCert, Key: clientKey}, wantErr: false, wantReqErr: false},
+		{in: TLSConfig{CAFingerprint: fingerprint, Cert: clientCert, Key: clientKey}, wantErr: false, wantReqErr: false},
+		{in: TLSConfig{CAFingerprint: strings.Join(colons, ":"), Cert: clientCert, Key: clientKey}, wantErr: false, wantReqErr: false},
+		{in: TLSConfig{InsecureSkipVerify: true, Cert: clientCert, Key: clientKey}, wantErr: false, wantReqErr: false},
+		{in: TLSConfig{Cert: clientCert, Key: clientKey}, wantErr: false, wantReqErr: true},
+		{in: TLSConfig{CAFingerprint: strings.Repeat("00", sha256.Size), Cert: clientCert, Key: clientKey}, wantErr: false, wantReqErr: true},
+		{in: TLSConfig{CACert: caCert}, wantErr: false, wantReqErr: true},
+		{in: TLSConfig{CACert: clientKey}, wantErr: true, wantReqErr: false},
+		{in: TLSConfig{CACert: caCert, Cert: clientCert}, wantErr: true, wantReqErr: false},
+		{in: TLSConfig{CAFingerprint: "abcd"}, wantErr: true, wantReqErr: false},
+		{in: TLSConfig{CAFingerprint: fingerprint, InsecureSkipVerify: true}, wantErr: true, wantReqErr: false},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			tp := &http.Transport{}
+			err := configureTLS(tp, tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("in: %v err: %v wantErr: %v", tt.in, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			client := &http.Client{Transport: tp}
+			res, err := client.Get(srv.URL)
+			if err == nil {
+				res.Body.Close()
+			}
+			if (err != nil) != tt.wantReqErr {
+				t.Fatalf("in: %v err: %v wantReqErr: %v", tt.in, err, tt.wantReqErr)
+			}
+		})
+	}
+}
+
+// writeClientCert writes a self-signed client certificate and its key to dir.
+func writeClientCert(t *testing.T, dir string) (cert, key string) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "escli"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, key = filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	if err := ioutil.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestCAFingerprint(t *testing.T) {
+	t.Parallel()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "escli CA"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageCertSign,
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca, err = x509.ParseCertificate(caDER); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(caDER)
+	fingerprint := hex.EncodeToString(sum[:])
+
+	// leaf returns a server certificate for ips and names signed by parent,
+	// or self-signed when parent is nil, followed by the CA certificate.
+	leaf := func(parent *x509.Certificate, parentKey *ecdsa.PrivateKey, ips []net.IP, names []string) tls.Certificate {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: "escli"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			IPAddresses:  ips,
+			DNSNames:     names,
+		}
+		if parent == nil {
+			parent, parentKey = tmpl, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tls.Certificate{Certificate: [][]byte{der, caDER}, PrivateKey: key}
+	}
+	localhost := []net.IP{net.ParseIP("127.0.0.1")}
+
+	tests := []struct {
+		in      tls.Certificate
+		wantErr bool
+	}{
+		{in: leaf(ca, caKey, localhost, nil), wantErr: false},
+		// A self-signed leaf presented with the public CA certificate.
+		{in: leaf(nil, nil, localhost, nil), wantErr: true},
+		{in: leaf(ca, caKey, nil, []string{"es.example.com"}), wantErr: true},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			srv.TLS = &tls.Config{Certificates: []tls.Certificate{tt.in}}
+			srv.StartTLS()
+			defer srv.Close()
+
+			tp := &http.Transport{}
+			if err := configureTLS(tp, TLSConfig{CAFingerprint: fingerprint}); err != nil {
+				t.Fatal(err)
+			}
+			res, err := (&http.Client{Transport: tp}).Get(srv.URL)
+			if err == nil {
+				res.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("in: %v err: %v wantErr: %v", i, err, tt.wantErr)
+			}
+		})
+	}
+}
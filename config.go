@@ -0,0 +1,408 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+)
+
+// Config wraps the escli configuration file.
+type Config struct {
+	CurrentContext string    `yaml:"current-context"`
+	Contexts       []Context `yaml:"contexts"`
+}
+
+// Context is a named set of defaults for an Elasticsearch cluster.
+type Context struct {
+	Name        string   `yaml:"name"`
+	Addresses   []string `yaml:"addresses,omitempty"`
+	Credentials `yaml:",inline"`
+	TLS         TLSConfig `yaml:"tls,omitempty"`
+	AWS         AWSConfig `yaml:"aws,omitempty"`
+	Index       string    `yaml:"index,omitempty"`
+	TimeField   string    `yaml:"time-field,omitempty"`
+	TimeFormat  string    `yaml:"time-format,omitempty"`
+}
+
+// TLSConfig is the TLS configuration of a context.
+type TLSConfig struct {
+	// CACert is the PEM file of the certificate authorities trusted in
+	// addition to the system ones.
+	CACert string `yaml:"ca-cert,omitempty"`
+	// Cert and Key are the PEM files of the client certificate and its key.
+	Cert string `yaml:"cert,omitempty"`
+	Key  string `yaml:"key,omitempty"`
+	// CAFingerprint is the SHA-256 fingerprint of a certificate of the
+	// server chain, trusted as the only certificate authority.
+	CAFingerprint      string `yaml:"ca-fingerprint,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure-skip-verify,omitempty"`
+}
+
+// Context returns the context called name.
+func (cfg *Config) Context(name string) (*Context, error) {
+	names := make([]string, 0, len(cfg.Contexts))
+	for i := range cfg.Contexts {
+		if cfg.Contexts[i].Name == name {
+			return &cfg.Contexts[i], nil
+		}
+		names = append(names, cfg.Contexts[i].Name)
+	}
+	if s := suggest(name, names); s != "" {
+		return nil, fmt.Errorf("unknown context %q; did you mean %q?", name, s)
+	}
+	return nil, fmt.Errorf("unknown context %q", name)
+}
+
+// defaultConfigPath returns the location of the configuration file,
+// e.g. ~/.config/escli/config.yaml.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "escli", "config.yaml")
+}
+
+// loadConfig reads the configuration file. A missing file is not an error
+// and results in an empty configuration.
+func loadConfig(path string) (*Config, error) {
+	var cfg Config
+	if path == "" {
+		return &cfg, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("Error parsing the config file %s: %s", path, err)
+	}
+	return &cfg, nil
+}
+
+// saveConfig writes the configuration file, readable by the user only as it
+// may hold passwords.
+func saveConfig(path string, cfg *Config) error {
+	if path == "" {
+		return fmt.Errorf("no config file, set --config")
+	}
+	b, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
+}
+
+// currentContext returns the context selected by the --context flag or the
+// configuration file. An empty context is returned when none is selected.
+func currentContext(c *cli.Context) (*Context, error) {
+	cfg, err := loadConfig(c.String("config"))
+	if err != nil {
+		return nil, err
+	}
+	name := firstNonEmpty(c.String("context"), cfg.CurrentContext)
+	if name == "" {
+		return &Context{}, nil
+	}
+	ctx, err := cfg.Context(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", c.String("config"), err)
+	}
+	return ctx, nil
+}
+
+var configCommand = &cli.Command{
+	Name:  "config",
+	Usage: "Manage the contexts of the config file",
+	Subcommands: []*cli.Command{
+		{
+			Name:         "use-context",
+			Usage:        "Set the current context",
+			ArgsUsage:    "NAME",
+			Action:       useContextAction,
+			BashComplete: completeContexts,
+		},
+		{
+			Name:   "get-contexts",
+			Usage:  "List the contexts",
+			Action: getContextsAction,
+			Flags: []cli.Flag{
+				outputFlag("table"),
+				columnsFlag,
+			},
+		},
+		{
+			Name:         "set-context",
+			Usage:        "Create a context or update the settings given",
+			ArgsUsage:    "NAME",
+			Action:       setContextAction,
+			BashComplete: completeContexts,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "address",
+					Usage: "Elasticsearch url, or comma separated urls of the nodes",
+				},
+				&cli.StringFlag{
+					Name:  "username",
+					Usage: "Elasticsearch username",
+				},
+				&cli.StringFlag{
+					Name:  "password",
+					Usage: "Elasticsearch password",
+				},
+				&cli.StringFlag{
+					Name:  "api-key",
+					Usage: "Elasticsearch API key, as id:key or encoded",
+				},
+				&cli.StringFlag{
+					Name:  "service-token",
+					Usage: "Elasticsearch service account token",
+				},
+				&cli.StringFlag{
+					Name:  "bearer-token",
+					Usage: "OAuth2 or JWT bearer token",
+				},
+				&cli.StringFlag{
+					Name:    "cacert",
+					Aliases: []string{"ca-cert"},
+					Usage:   "PEM file of the certificate authorities to trust",
+				},
+				&cli.StringFlag{
+					Name:  "cert",
+					Usage: "PEM file of the client certificate",
+				},
+				&cli.StringFlag{
+					Name:  "key",
+					Usage: "PEM file of the key of the client certificate",
+				},
+				&cli.StringFlag{
+					Name:  "ca-fingerprint",
+					Usage: "SHA-256 fingerprint of the CA certificate of the server to trust",
+				},
+				&cli.BoolFlag{
+					Name:  "insecure-skip-verify",
+					Usage: "Do not verify the certificate of the server",
+				},
+				&cli.BoolFlag{
+					Name:  "aws-sigv4",
+					Usage: "Sign the requests with AWS Signature Version 4",
+				},
+				&cli.StringFlag{
+					Name:  "aws-region",
+					Usage: "AWS region of the domain",
+				},
+				&cli.StringFlag{
+					Name:  "aws-service",
+					Usage: "AWS service signing name, es or aoss",
+				},
+				&cli.StringFlag{
+					Name:  "aws-profile",
+					Usage: "AWS profile of the shared credentials and config files",
+				},
+				&cli.StringFlag{
+					Name:  "index",
+					Usage: "Default index",
+				},
+				&cli.StringFlag{
+					Name:  "time-field",
+					Usage: "Default time field",
+				},
+				&cli.StringFlag{
+					Name:  "time-format",
+					Usage: "Default format of the time field",
+				},
+				&cli.BoolFlag{
+					Name:  "current",
+					Usage: "Also set the context as the current context",
+				},
+			},
+		},
+		{
+			Name:   "view",
+			Usage:  "Show the config file",
+			Action: viewConfigAction,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "raw",
+					Usage: "Show the passwords, API keys and tokens",
+				},
+			},
+		},
+	},
+}
+
+// completeContexts prints the context names for shell completion.
+func completeContexts(c *cli.Context) {
+	if c.NArg() > 0 {
+		return
+	}
+	cfg, err := loadConfig(c.Lineage()[1].String("config"))
+	if err != nil {
+		return
+	}
+	for _, ctx := range cfg.Contexts {
+		fmt.Fprintln(c.App.Writer, ctx.Name)
+	}
+}
+
+func useContextAction(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("usage: escli config use-context NAME")
+	}
+	path := c.String("config")
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	ctx, err := cfg.Context(c.Args().First())
+	if err != nil {
+		return err
+	}
+	cfg.CurrentContext = ctx.Name
+	if err := saveConfig(path, cfg); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "Switched to context %q.\n", ctx.Name)
+	return nil
+}
+
+func getContextsAction(c *cli.Context) error {
+	cfg, err := loadConfig(c.String("config"))
+	if err != nil {
+		return err
+	}
+	current := firstNonEmpty(c.String("context"), cfg.CurrentContext)
+	columns := c.StringSlice("columns")
+	if len(columns) == 0 {
+		columns = []string{"current", "name", "addresses", "index"}
+	}
+	f, err := newFormatter(c.App.Writer, c.String("output"), columns)
+	if err != nil {
+		return err
+	}
+	for _, ctx := range cfg.Contexts {
+		var mark string
+		if ctx.Name == current {
+			mark = "*"
+		}
+		doc, err := json.Marshal(map[string]string{
+			"current":   mark,
+			"name":      ctx.Name,
+			"addresses": strings.Join(ctx.Addresses, ","),
+			"index":     ctx.Index,
+		})
+		if err != nil {
+			return err
+		}
+		if err := f.Write(doc); err != nil {
+			return err
+		}
+	}
+	return f.Close()
+}
+
+func setContextAction(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("usage: escli config set-context [options] NAME")
+	}
+	name := c.Args().First()
+	path := c.String("config")
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	var ctx *Context
+	for i := range cfg.Contexts {
+		if cfg.Contexts[i].Name == name {
+			ctx = &cfg.Contexts[i]
+		}
+	}
+	verb := "Modified"
+	if ctx == nil {
+		cfg.Contexts = append(cfg.Contexts, Context{Name: name})
+		ctx = &cfg.Contexts[len(cfg.Contexts)-1]
+		verb = "Created"
+	}
+
+	// Only the flags given on the command line replace the settings.
+	if c.IsSet("address") {
+		ctx.Addresses = splitIndices([]string{c.String("address")})
+	}
+	for flag, v := range map[string]*string{
+		"username":       &ctx.Username,
+		"password":       &ctx.Password,
+		"api-key":        &ctx.APIKey,
+		"service-token":  &ctx.ServiceToken,
+		"bearer-token":   &ctx.BearerToken,
+		"cacert":         &ctx.TLS.CACert,
+		"cert":           &ctx.TLS.Cert,
+		"key":            &ctx.TLS.Key,
+		"ca-fingerprint": &ctx.TLS.CAFingerprint,
+		"aws-region":     &ctx.AWS.Region,
+		"aws-service":    &ctx.AWS.Service,
+		"aws-profile":    &ctx.AWS.Profile,
+		"index":          &ctx.Index,
+		"time-field":     &ctx.TimeField,
+		"time-format":    &ctx.TimeFormat,
+	} {
+		if c.IsSet(flag) {
+			*v = c.String(flag)
+		}
+	}
+	if c.IsSet("insecure-skip-verify") {
+		ctx.TLS.InsecureSkipVerify = c.Bool("insecure-skip-verify")
+	}
+	if c.IsSet("aws-sigv4") {
+		ctx.AWS.SigV4 = c.Bool("aws-sigv4")
+	}
+	if err := ctx.Credentials.Validate(); err != nil {
+		return fmt.Errorf("context %q: %s", name, err)
+	}
+	if err := configureTLS(&http.Transport{}, ctx.TLS); err != nil {
+		return fmt.Errorf("context %q: %s", name, err)
+	}
+	if c.Bool("current") {
+		cfg.CurrentContext = name
+	}
+	if err := saveConfig(path, cfg); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "%s context %q.\n", verb, name)
+	return nil
+}
+
+func viewConfigAction(c *cli.Context) error {
+	cfg, err := loadConfig(c.String("config"))
+	if err != nil {
+		return err
+	}
+	if !c.Bool("raw") {
+		for i := range cfg.Contexts {
+			cr := &cfg.Contexts[i].Credentials
+			for _, v := range []*string{&cr.Password, &cr.APIKey, &cr.ServiceToken, &cr.BearerToken} {
+				if *v != "" {
+					*v = "REDACTED"
+				}
+			}
+		}
+	}
+	b, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	_, err = c.App.Writer.Write(b)
+	return err
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestConfigCommand(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in      [][]string
+		want    string
+		wantErr bool
+	}{
+		{
+			in: [][]string{
+				{"config", "set-context", "--address", "https://prod:9200,https://prod2:9200", "--username", "admin", "--password", "secret", "--index", "waf-*", "prod"},
+				{"config", "set-context", "--address", "http://localhost:9200", "--current", "local"},
+				{"config", "get-contexts", "-o", "csv"},
+			},
+			want:    "Created context \"prod\".\nCreated context \"local\".\ncurrent,name,addresses,index\n,prod,\"https://prod:9200,https://prod2:9200\",waf-*\n*,local,http://localhost:9200,\n",
+			wantErr: false,
+		},
+		{
+			in: [][]string{
+				{"config", "set-context", "--address", "https://prod:9200", "--password", "secret", "prod"},
+				{"config", "set-context", "--index", "waf-*", "prod"},
+				{"config", "use-context", "prod"},
+				{"config", "view"},
+			},
+			want:    "Created context \"prod\".\nModified context \"prod\".\nSwitched to context \"prod\".\ncurrent-context: prod\ncontexts:\n- name: prod\n  addresses:\n  - https://prod:9200\n  password: REDACTED\n  index: waf-*\n",
+			wantErr: false,
+		},
+		{
+			in: [][]string{
+				{"config", "set-context", "prod"},
+				{"--context", "local", "config", "get-contexts", "-o", "csv"},
+			},
+			want:    "Created context \"prod\".\ncurrent,name,addresses,index\n,prod,,\n",
+			wantErr: false,
+		},
+		{
+			in: [][]string{
+				{"config", "set-context", "staging"},
+				{"config", "use-context", "stagin"},
+			},
+			want:    "Created context \"staging\".\n",
+			wantErr: true,
+		},
+		{
+			in:      [][]string{{"config", "use-context"}},
+			want:    "",
+			wantErr: true,
+		},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			var out bytes.Buffer
+			var err error
+			for _, args := range tt.in {
+				app := newApp()
+				app.Writer = &out
+				app.ExitErrHandler = func(*cli.Context, error) {}
+				if err = app.Run(append([]string{"escli", "--config", path}, args...)); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("in: %v err: %v wantErr: %v", tt.in, err, tt.wantErr)
+			}
+			if got := out.String(); got != tt.want {
+				t.Fatalf("in: %v got: %v want: %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCurrentContext(t *testing.T) {
+	t.Parallel()
+	cfg := &Config{CurrentContext: "prod", Contexts: []Context{{Name: "prod", Index: "waf-*"}, {Name: "staging", Index: "staging-*"}}}
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "prod", want: "waf-*", wantErr: false},
+		{in: "staging", want: "staging-*", wantErr: false},
+		{in: "Prod", want: "", wantErr: true},
+		{in: "dev", want: "", wantErr: true},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			ctx, err := cfg.Context(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("in: %v err: %v wantErr: %v", tt.in, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if ctx.Index != tt.want {
+				t.Fatalf("in: %v got: %v want: %v", tt.in, ctx.Index, tt.want)
+			}
+		})
+	}
+}
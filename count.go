@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+)
+
+// CountResponse wraps the Elasticsearch count response.
+type CountResponse struct {
+	Count int64 `json:"count"`
+}
+
+// IndexCountResponse wraps the response of the search counting the
+// documents of every index.
+type IndexCountResponse struct {
+	Hits struct {
+		Total struct {
+			Value int64 `json:"value"`
+		} `json:"total"`
+	} `json:"hits"`
+	Aggregations struct {
+		Indices struct {
+			Buckets []struct {
+				Key      string `json:"key"`
+				DocCount int64  `json:"doc_count"`
+			} `json:"buckets"`
+		} `json:"indices"`
+	} `json:"aggregations"`
+}
+
+// IndexCount is the number of matching documents of an index.
+type IndexCount struct {
+	Index string `json:"index"`
+	Count int64  `json:"count"`
+}
+
+// MaxCountIndices is the maximum number of indices counted by --by-index.
+const MaxCountIndices = 10000
+
+var countByIndexFlag = &cli.BoolFlag{
+	Name:  "by-index",
+	Usage: "Count the matching documents of every index",
+}
+
+var countCommand = &cli.Command{
+	Name:         "count",
+	Usage:        "Count the documents matching a query",
+	Action:       countAction,
+	BashComplete: completeSearch,
+	Flags: append([]cli.Flag{
+		indexFlag,
+		outputFlag("text", "text"),
+		columnsFlag,
+		countByIndexFlag,
+	}, queryFlags()...),
+}
+
+func countAction(c *cli.Context) error {
+	query, err := buildQuery(c)
+	if err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(query)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msgf("query: %s", body)
+	if c.Bool("render-only") {
+		fmt.Fprintf(c.App.Writer, "%s\n", body)
+		return nil
+	}
+	return countQuery(c, body)
+}
+
+// countQuery writes the number of documents matching the query of the
+// search request body, in total or by index with --by-index.
+func countQuery(c *cli.Context, body []byte) error {
+	var b struct {
+		Query json.RawMessage `json:"query"`
+	}
+	if err := json.Unmarshal(body, &b); err != nil {
+		return err
+	}
+
+	es, err := newClient(c)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := withSignals(context.Background())
+	defer cancel()
+
+	idx, err := indices(c)
+	if err != nil {
+		return err
+	}
+	if err := resolveIndices(ctx, es, idx); err != nil {
+		return err
+	}
+
+	w := c.App.Writer
+	format, columns := c.String("output"), c.StringSlice("columns")
+	if !c.Bool("by-index") {
+		n, err := count(ctx, es, idx, b.Query)
+		if err != nil {
+			return err
+		}
+		if format == "text" {
+			fmt.Fprintf(w, "%d\n", n)
+			return nil
+		}
+		return writeDocument(w, format, columns, CountResponse{Count: n})
+	}
+
+	counts, err := countByIndex(ctx, es, idx, b.Query)
+	if err != nil {
+		return err
+	}
+	if format == "text" {
+		format = "table"
+	}
+	f, err := newFormatter(w, format, columns)
+	if err != nil {
+		return err
+	}
+	for _, v := range counts {
+		doc, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if err := f.Write(doc); err != nil {
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// count returns the number of documents of idx matching query with the count API.
+func count(ctx context.Context, es *elasticsearch.Client, idx []string, query json.RawMessage) (int64, error) {
+	body, err := json.Marshal(map[string]json.RawMessage{"query": query})
+	if err != nil {
+		return 0, err
+	}
+	res, err := es.Count(
+		es.Count.WithContext(ctx),
+		es.Count.WithIndex(idx...),
+		es.Count.WithBody(strings.NewReader(string(body))),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("Error getting response: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return 0, responseError(res)
+	}
+
+	var r CountResponse
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return 0, fmt.Errorf("Error parsing the response body: %s", err)
+	}
+	return r.Count, nil
+}
+
+// countByIndex returns the number of documents matching query of every index
+// of idx in index name order. The count API cannot group, so the documents
+// are counted with a terms aggregation on _index.
+func countByIndex(ctx context.Context, es *elasticsearch.Client, idx []string, query json.RawMessage) ([]IndexCount, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"query":            query,
+		"size":             0,
+		"track_total_hits": true,
+		"aggs": map[string]interface{}{
+			"indices": map[string]interface{}{
+				"terms": map[string]interface{}{
+					"field": "_index",
+					"size":  MaxCountIndices,
+					"order": map[string]string{"_key": "asc"},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	res, err := es.Search(
+		es.Search.WithContext(ctx),
+		es.Search.WithIndex(idx...),
+		es.Search.WithBody(strings.NewReader(string(body))),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting response: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, responseError(res)
+	}
+
+	var r IndexCountResponse
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("Error parsing the response body: %s", err)
+	}
+	counts := make([]IndexCount, 0, len(r.Aggregations.Indices.Buckets))
+	for _, b := range r.Aggregations.Indices.Buckets {
+		counts = append(counts, IndexCount{Index: b.Key, Count: b.DocCount})
+	}
+	log.Debug().Msgf("count: %d documents in %d indices", r.Hits.Total.Value, len(counts))
+	return counts, nil
+}
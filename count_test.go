@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/urfave/cli/v2"
+)
+
+// fakeCounts counts the in-memory documents of every index, given by their
+// timestamps, and records the last query.
+type fakeCounts struct {
+	docs  map[string][]time.Time
+	query json.RawMessage
+}
+
+// match returns the indices matching the names and patterns. A missing
+// concrete index is an error like in Elasticsearch.
+func (x *fakeCounts) match(names []string) ([]string, error) {
+	var idx []string
+	for _, name := range names {
+		found := false
+		for index := range x.docs {
+			if ok, _ := path.Match(name, index); ok {
+				idx = append(idx, index)
+				found = true
+			}
+		}
+		if !found && !strings.Contains(name, "*") {
+			return nil, fmt.Errorf("no such index [%s]", name)
+		}
+	}
+	return idx, nil
+}
+
+func (x *fakeCounts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] == "_resolve" {
+		parts = []string{parts[2], parts[0]}
+	}
+	if len(parts) != 2 {
+		http.Error(w, r.URL.Path, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	idx, err := x.match(strings.Split(parts[0], ","))
+	if err != nil {
+		fakeError(w, http.StatusNotFound, "index_not_found_exception", err.Error())
+		return
+	}
+	if parts[1] == "_resolve" {
+		indices := make([]map[string]string, len(idx))
+		for i, index := range idx {
+			indices[i] = map[string]string{"name": index}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"indices": indices, "aliases": []string{}, "data_streams": []string{}})
+		return
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var body struct {
+		Query struct {
+			Bool struct {
+				Filter []struct {
+					Range map[string]struct {
+						Gte string `json:"gte"`
+						Lte string `json:"lte"`
+					} `json:"range"`
+				} `json:"filter"`
+			} `json:"bool"`
+		} `json:"query"`
+		Aggs struct {
+			Indices struct {
+				Terms struct {
+					Field string `json:"field"`
+				} `json:"terms"`
+			} `json:"indices"`
+		} `json:"aggs"`
+	}
+	var raw struct {
+		Query json.RawMessage `json:"query"`
+	}
+	if err := json.Unmarshal(b, &body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	x.query = raw.Query
+
+	// Only the time filter is applied, the other clauses match every document.
+	gte, lte := time.Time{}, time.Unix(1<<40, 0)
+	for _, f := range body.Query.Bool.Filter {
+		if rg, ok := f.Range["@timestamp"]; ok {
+			gte, _ = time.Parse(time.RFC3339Nano, rg.Gte)
+			lte, _ = time.Parse(time.RFC3339Nano, rg.Lte)
+		}
+	}
+	sort.Strings(idx)
+	var total int
+	buckets := []map[string]interface{}{}
+	for _, index := range idx {
+		n := 0
+		for _, t := range x.docs[index] {
+			if !t.Before(gte) && !t.After(lte) {
+				n++
+			}
+		}
+		if n > 0 {
+			buckets = append(buckets, map[string]interface{}{"key": index, "doc_count": n})
+		}
+		total += n
+	}
+
+	switch parts[1] {
+	case "_count":
+		_ = json.NewEncoder(w).Encode(map[string]int{"count": total})
+	case "_search":
+		if body.Aggs.Indices.Terms.Field != "_index" {
+			fakeError(w, http.StatusBadRequest, "illegal_argument_exception", "no terms aggregation on _index")
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"took":         1,
+			"hits":         map[string]interface{}{"total": map[string]int{"value": total}, "hits": []string{}},
+			"aggregations": map[string]interface{}{"indices": map[string]interface{}{"buckets": buckets}},
+		})
+	default:
+		http.Error(w, r.URL.Path, http.StatusBadRequest)
+	}
+}
+
+// fakeError writes an Elasticsearch error response.
+func fakeError(w http.ResponseWriter, status int, typ, reason string) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  map[string]string{"type": typ, "reason": reason},
+		"status": status,
+	})
+}
+
+func newFakeCounts() *fakeCounts {
+	at := func(s string) time.Time {
+		t, _ := time.Parse(time.RFC3339, "2020-12-23T"+s+"Z")
+		return t
+	}
+	return &fakeCounts{docs: map[string][]time.Time{
+		"waf-a": {at("13:10:00"), at("13:20:00"), at("15:00:00")},
+		"waf-b": {at("13:30:00")},
+		"waf-c": {at("12:00:00")},
+	}}
+}
+
+func TestCountQuery(t *testing.T) {
+	t.Parallel()
+	const clause = `{"range":{"@timestamp":{"format":"strict_date_optional_time","gte":"2020-12-23T13:04:05Z","lte":"2020-12-23T14:15:16Z"}}}`
+	filtered := func(must string) string {
+		return `{"bool":{"filter":[` + clause + `],"must":[` + must + `]}}`
+	}
+	type in struct {
+		index, rule, query, output string
+		kql, noTimeFilter, byIndex bool
+	}
+	tests := []struct {
+		in        in
+		wantQuery string
+		want      string
+		wantErr   bool
+	}{
+		{in: in{index: "waf-*", output: "text"}, wantQuery: filtered(`{"match_all":{}}`), want: "3\n", wantErr: false},
+		{in: in{index: "waf-*", output: "text", noTimeFilter: true}, wantQuery: `{"match_all":{}}`, want: "5\n", wantErr: false},
+		{in: in{index: "waf-a", output: "json"}, wantQuery: filtered(`{"match_all":{}}`), want: "{\n  \"count\": 2\n}\n", wantErr: false},
+		{in: in{index: "waf-*", rule: "Blocked", output: "text"}, wantQuery: filtered(`{"match":{"action":"BLOCK"}}`), want: "3\n", wantErr: false},
+		{in: in{index: "waf-*", query: "action:BLOCK AND httpRequest.country:JP", output: "text"}, wantQuery: filtered(`{"query_string":{"query":"action:BLOCK AND httpRequest.country:JP"}}`), want: "3\n", wantErr: false},
+		{in: in{index: "waf-*", query: "action:BLOCK", kql: true, output: "text"}, wantQuery: filtered(`{"match":{"action":"BLOCK"}}`), want: "3\n", wantErr: false},
+		{in: in{index: "waf-*", rule: "Blocked", query: "httpRequest.country:JP", kql: true, output: "text"}, wantQuery: filtered(`{"bool":{"must":[{"match":{"action":"BLOCK"}},{"match":{"httpRequest.country":"JP"}}]}}`), want: "3\n", wantErr: false},
+		{in: in{index: "waf-*", output: "csv", byIndex: true}, wantQuery: filtered(`{"match_all":{}}`), want: "index,count\nwaf-a,2\nwaf-b,1\n", wantErr: false},
+		{in: in{index: "waf-*", query: "action:BLOCK", kql: true, output: "csv", byIndex: true, noTimeFilter: true}, wantQuery: `{"match":{"action":"BLOCK"}}`, want: "index,count\nwaf-a,3\nwaf-b,1\nwaf-c,1\n", wantErr: false},
+		{in: in{index: "waf-d", output: "text"}, wantErr: true},
+		{in: in{index: "waf-*", rule: "AnonymousIp", output: "text"}, wantErr: true},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			x := newFakeCounts()
+			srv := httptest.NewServer(x)
+			defer srv.Close()
+			flags := []cli.Flag{
+				&cli.StringFlag{Name: "address"},
+				&cli.StringFlag{Name: "config"},
+				&cli.StringSliceFlag{Name: "index"},
+				&cli.StringFlag{Name: "output"},
+				&cli.StringSliceFlag{Name: "columns"},
+				&cli.BoolFlag{Name: "by-index"},
+				&cli.StringFlag{Name: "rule"},
+				&cli.StringFlag{Name: "query"},
+				&cli.BoolFlag{Name: "kql"},
+				&cli.StringFlag{Name: "since"},
+				&cli.StringFlag{Name: "until"},
+				&cli.StringFlag{Name: "timezone", Value: "UTC"},
+				&cli.StringFlag{Name: "rules-dir", Value: "./testdata/rules"},
+				&cli.BoolFlag{Name: "no-time-filter"},
+			}
+			set := flag.NewFlagSet("test", 0)
+			for _, fl := range flags {
+				_ = fl.Apply(set)
+			}
+			set.Parse([]string{"--address", srv.URL, "--index", tt.in.index, "--output", tt.in.output, fmt.Sprintf("--by-index=%t", tt.in.byIndex), "--rule", tt.in.rule, "--query", tt.in.query, fmt.Sprintf("--kql=%t", tt.in.kql), "--since", "2020-12-23 13:04:05", "--until", "2020-12-23 14:15:16", fmt.Sprintf("--no-time-filter=%t", tt.in.noTimeFilter)})
+			var out bytes.Buffer
+			c := cli.NewContext(&cli.App{Writer: &out}, set, nil)
+			err := func() error {
+				query, err := buildQuery(c)
+				if err != nil {
+					return err
+				}
+				body, err := ioutil.ReadAll(query)
+				if err != nil {
+					return err
+				}
+				return countQuery(c, body)
+			}()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("in: %v err: %v wantErr: %v", tt.in, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := string(x.query); got != tt.wantQuery {
+				t.Fatalf("in: %v got: %v want: %v", tt.in, got, tt.wantQuery)
+			}
+			if got := out.String(); got != tt.want {
+				t.Fatalf("in: %v got: %v want: %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountByIndex(t *testing.T) {
+	t.Parallel()
+	const matchAll = `{"match_all":{}}`
+	const clause = `{"bool":{"filter":[{"range":{"@timestamp":{"gte":"2020-12-23T13:00:00Z","lte":"2020-12-23T14:00:00Z"}}}],"must":[{"match_all":{}}]}}`
+	type in struct {
+		idx   []string
+		query string
+	}
+	tests := []struct {
+		in      in
+		want    []IndexCount
+		wantErr bool
+	}{
+		{in: in{idx: []string{"waf-*"}, query: matchAll}, want: []IndexCount{{"waf-a", 3}, {"waf-b", 1}, {"waf-c", 1}}, wantErr: false},
+		{in: in{idx: []string{"waf-*"}, query: clause}, want: []IndexCount{{"waf-a", 2}, {"waf-b", 1}}, wantErr: false},
+		{in: in{idx: []string{"waf-b", "waf-a"}, query: matchAll}, want: []IndexCount{{"waf-a", 3}, {"waf-b", 1}}, wantErr: false},
+		{in: in{idx: []string{"log-*"}, query: matchAll}, want: []IndexCount{}, wantErr: false},
+		{in: in{idx: []string{"waf-d"}, query: matchAll}, want: nil, wantErr: true},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			srv := httptest.NewServer(newFakeCounts())
+			defer srv.Close()
+			es, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{srv.URL}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := countByIndex(context.Background(), es, tt.in.idx, json.RawMessage(tt.in.query))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("in: %v err: %v wantErr: %v", tt.in, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("in: %v got: %v want: %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	b64 "encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// HeadersPath is the gjson path of the request headers of a hit, a list of
+// objects with a name and a value as in the AWS WAF logs.
+const HeadersPath = "_source.httpRequest.headers"
+
+// Extractor extracts and decodes a header or a cookie of the hits.
+type Extractor struct {
+	// Source is "header" or "cookie".
+	Source string
+	// Name is the header or cookie name, a glob pattern such as
+	// amplitude_id_*. Header names are case-insensitive.
+	Name string
+	// Decoder is the name of the decoder of the values.
+	Decoder string
+}
+
+// Extracted is a value found by an Extractor.
+type Extracted struct {
+	// Name is the name of the header or cookie.
+	Name string
+	// Value is the decoded value as JSON.
+	Value json.RawMessage
+	// Raw is the value before decoding.
+	Raw string
+}
+
+// ExtractError records a header or cookie value that failed to decode.
+type ExtractError struct {
+	Source  string
+	Name    string
+	Decoder string
+	Value   string
+	Err     error
+}
+
+func (e *ExtractError) Error() string {
+	return fmt.Sprintf("Error decoding the %s %s as %s: %s", e.Source, e.Name, e.Decoder, e.Err)
+}
+
+func (e *ExtractError) Unwrap() error { return e.Err }
+
+// decoders decode a header or cookie value into JSON.
+var decoders = map[string]func(s string) (json.RawMessage, error){
+	"raw":       decodeRaw,
+	"base64":    decodeBase64,
+	"base64url": decodeBase64URL,
+	"jwt":       decodeJWT,
+	"urljson":   decodeURLJSON,
+	"ga":        decodeGA,
+}
+
+// extractorPresets are the extractors selected by name with --extract.
+var extractorPresets = map[string]string{
+	"amplitude": "cookie:*amplitude_id*:base64",
+	"ga":        "cookie:_ga:ga",
+}
+
+// parseExtractor parses an extractor SOURCE:NAME[:DECODER] or the name of a
+// preset. The decoder defaults to raw.
+func parseExtractor(spec string) (*Extractor, error) {
+	if preset, ok := extractorPresets[spec]; ok {
+		spec = preset
+	}
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[1] == "" {
+		return nil, fmt.Errorf("invalid extractor %q, want header:NAME[:DECODER], cookie:NAME[:DECODER] or one of %s", spec, strings.Join(presetNames(), ", "))
+	}
+	e := &Extractor{Source: parts[0], Name: parts[1], Decoder: "raw"}
+	if len(parts) == 3 {
+		e.Decoder = parts[2]
+	}
+	if _, err := path.Match(e.Name, ""); err != nil {
+		return nil, fmt.Errorf("invalid extractor %q: %s", spec, err)
+	}
+	if e.Source != "header" && e.Source != "cookie" {
+		return nil, fmt.Errorf("invalid extractor %q: unknown source %q, want header or cookie", spec, e.Source)
+	}
+	if _, ok := decoders[e.Decoder]; !ok {
+		return nil, fmt.Errorf("invalid extractor %q: unknown decoder %q, want one of %s", spec, e.Decoder, strings.Join(decoderNames(), ", "))
+	}
+	return e, nil
+}
+
+func presetNames() []string {
+	names := make([]string, 0, len(extractorPresets))
+	for name := range extractorPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func decoderNames() []string {
+	names := make([]string, 0, len(decoders))
+	for name := range decoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Extract returns the decoded values of the matching headers or cookies of hit.
+func (e *Extractor) Extract(hit gjson.Result) ([]Extracted, error) {
+	var values []Extracted
+	for _, header := range hit.Get(HeadersPath).Array() {
+		v, err := e.FromHeader(header.Get("name").Str, header.Get("value").Str)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v...)
+	}
+	return values, nil
+}
+
+// FromHeader returns the decoded values of the header called name if it
+// matches, or of its matching cookies if it is a cookie header.
+func (e *Extractor) FromHeader(name, value string) ([]Extracted, error) {
+	var values []Extracted
+	switch e.Source {
+	case "header":
+		if !e.match(strings.ToLower(name), strings.ToLower(e.Name)) {
+			return nil, nil
+		}
+		v, err := e.decode(name, value)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	case "cookie":
+		if !strings.EqualFold(name, "cookie") {
+			return nil, nil
+		}
+		for _, cookie := range parseCookies(value) {
+			if !e.match(cookie.Name, e.Name) {
+				continue
+			}
+			v, err := e.decode(cookie.Name, cookie.Value)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+func (e *Extractor) match(name, pattern string) bool {
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+func (e *Extractor) decode(name, value string) (Extracted, error) {
+	v, err := decoders[e.Decoder](value)
+	if err != nil {
+		return Extracted{}, &ExtractError{Source: e.Source, Name: name, Decoder: e.Decoder, Value: value, Err: err}
+	}
+	return Extracted{Name: name, Value: v, Raw: value}, nil
+}
+
+// Cookie is a name and value pair of a cookie header.
+type Cookie struct {
+	Name  string
+	Value string
+}
+
+// parseCookies returns the cookies of a cookie header value.
+func parseCookies(header string) []Cookie {
+	var cookies []Cookie
+	for _, s := range strings.Split(header, ";") {
+		s = strings.TrimSpace(s)
+		if i := strings.Index(s, "="); i > 0 {
+			cookies = append(cookies, Cookie{Name: s[:i], Value: trimNextEqual(s)})
+		}
+	}
+	return cookies
+}
+
+// newExtractHit returns the hitProcessor outputting a document per value
+// extracted by e. The fields of decoded JSON objects become the fields of the
+// document, other values its value field.
+func newExtractHit(e *Extractor) hitProcessor {
+	return func(hit gjson.Result) ([]interface{}, error) {
+		values, err := e.Extract(hit)
+		if err != nil {
+			return nil, err
+		}
+		docs := make([]interface{}, 0, len(values))
+		for _, v := range values {
+			doc := make(map[string]json.RawMessage)
+			if v.Value[0] == '{' {
+				if err := json.Unmarshal(v.Value, &doc); err != nil {
+					return nil, err
+				}
+			} else {
+				doc["value"] = v.Value
+			}
+			for k, s := range map[string]string{"_index": hit.Get("_index").Str, "_id": hit.Get("_id").Str, e.Source: v.Name} {
+				b, err := json.Marshal(s)
+				if err != nil {
+					return nil, err
+				}
+				doc[k] = b
+			}
+			docs = append(docs, doc)
+		}
+		return docs, nil
+	}
+}
+
+// decodeRaw returns s as a JSON string.
+func decodeRaw(s string) (json.RawMessage, error) {
+	return json.Marshal(s)
+}
+
+// decodeBase64 decodes padded standard base64.
+func decodeBase64(s string) (json.RawMessage, error) {
+	b, err := b64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return jsonOrString(b)
+}
+
+// decodeBase64URL decodes URL-safe base64 with or without padding.
+func decodeBase64URL(s string) (json.RawMessage, error) {
+	b, err := b64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	return jsonOrString(b)
+}
+
+// decodeJWT returns the payload of a JSON Web Token, optionally preceded by
+// the Bearer scheme of an Authorization header. The signature is not verified.
+func decodeJWT(s string) (json.RawMessage, error) {
+	s = strings.TrimSpace(s)
+	if len(s) > 7 && strings.EqualFold(s[:7], "bearer ") {
+		s = strings.TrimSpace(s[7:])
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("not a JWT")
+	}
+	b, err := b64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(b) {
+		return nil, fmt.Errorf("the payload is not JSON")
+	}
+	return compact(b)
+}
+
+// decodeURLJSON decodes URL-encoded JSON.
+func decodeURLJSON(s string) (json.RawMessage, error) {
+	v, err := url.QueryUnescape(s)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid([]byte(v)) {
+		return nil, fmt.Errorf("not JSON")
+	}
+	return compact([]byte(v))
+}
+
+// decodeGA decodes a Google Analytics _ga or _gid cookie of the form
+// GA1.2.123456789.1234567890 into its version, domain depth, client id and
+// the time of the first visit.
+func decodeGA(s string) (json.RawMessage, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 4 || !strings.HasPrefix(parts[0], "GA") {
+		return nil, fmt.Errorf("not a GA1.DEPTH.RANDOM.TIMESTAMP value")
+	}
+	depth, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid domain depth %q", parts[1])
+	}
+	sec, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp %q", parts[3])
+	}
+	return json.Marshal(struct {
+		Version     string `json:"version"`
+		DomainDepth int    `json:"domain_depth"`
+		ClientID    string `json:"client_id"`
+		FirstVisit  string `json:"first_visit"`
+	}{parts[0], depth, parts[2] + "." + parts[3], time.Unix(sec, 0).UTC().Format(time.RFC3339)})
+}
+
+// jsonOrString returns b if it is a JSON object or array, and b as a JSON
+// string otherwise.
+func jsonOrString(b []byte) (json.RawMessage, error) {
+	if t := bytes.TrimSpace(b); len(t) > 0 && (t[0] == '{' || t[0] == '[') && json.Valid(t) {
+		return compact(t)
+	}
+	return json.Marshal(string(b))
+}
+
+func compact(b []byte) (json.RawMessage, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, b); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
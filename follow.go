@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/rs/zerolog/log"
+	"github.com/tidwall/gjson"
+	"github.com/urfave/cli/v2"
+)
+
+// follower polls a search for the documents newer than the ones already
+// output, like tail -f.
+//
+// Every poll searches the time range from the latest timestamp seen, less
+// overlap for the documents indexed late, until now in time order. The
+// documents of the overlapping window that were already output are skipped.
+type follower struct {
+	es    *elasticsearch.Client
+	index []string
+	opts  []func(*esapi.SearchRequest)
+	tf    *TimeFilter
+	// query returns the search request body filtered by the time range.
+	query      func(tf *TimeFilter) ([]byte, error)
+	size       int
+	overlap    time.Duration
+	tiebreaker string
+	since      time.Time
+	latest     time.Time
+	// seen holds the timestamps of the documents output in the overlapping
+	// window by _index and _id.
+	seen map[string]time.Time
+}
+
+func newFollower(es *elasticsearch.Client, index []string, opts []func(*esapi.SearchRequest), tf *TimeFilter, query func(tf *TimeFilter) ([]byte, error), size int, overlap time.Duration, tiebreaker string) *follower {
+	return &follower{
+		es:         es,
+		index:      index,
+		opts:       opts,
+		tf:         tf,
+		query:      query,
+		size:       size,
+		overlap:    overlap,
+		tiebreaker: tiebreaker,
+		since:      tf.Since,
+		latest:     tf.Since,
+		seen:       make(map[string]time.Time),
+	}
+}
+
+// Poll calls fn for every new document until now in time order and returns
+// the number of the new documents.
+func (f *follower) Poll(ctx context.Context, now time.Time, fn func(hit []byte) error) (int, error) {
+	from := f.latest.Add(-f.overlap)
+	if from.Before(f.since) {
+		from = f.since
+	}
+	for id, t := range f.seen {
+		if t.Before(from) {
+			delete(f.seen, id)
+		}
+	}
+	sort := []interface{}{map[string]string{f.tf.Field: "asc"}}
+	if f.tiebreaker != "" {
+		sort = append(sort, map[string]string{f.tiebreaker: "asc"})
+	}
+
+	var (
+		after json.RawMessage
+		n     int
+	)
+	for {
+		tf := *f.tf
+		tf.Since, tf.Until = from, now
+		b, err := f.query(&tf)
+		if err != nil {
+			return n, err
+		}
+		values := map[string]interface{}{"sort": sort}
+		if after != nil {
+			values["search_after"] = after
+		}
+		body, err := mergeBody(b, values)
+		if err != nil {
+			return n, err
+		}
+		opts := append([]func(*esapi.SearchRequest){
+			f.es.Search.WithContext(ctx),
+			f.es.Search.WithIndex(f.index...),
+			f.es.Search.WithBody(bytes.NewReader(body)),
+		}, f.opts...)
+		res, err := f.es.Search(opts...)
+		if err != nil {
+			return n, fmt.Errorf("Error getting response: %s", err)
+		}
+
+		var (
+			page Page
+			last gjson.Result
+		)
+		err = readPage(res, &page, func(hit []byte) error {
+			h := gjson.ParseBytes(hit)
+			last = h.Get("sort")
+			t, err := f.timestamp(last.Get("0"))
+			if err != nil {
+				return err
+			}
+			id := h.Get("_index").Str + "/" + h.Get("_id").Str
+			if _, ok := f.seen[id]; ok {
+				return nil
+			}
+			f.seen[id] = t
+			if t.After(f.latest) {
+				f.latest = t
+			}
+			n++
+			return fn(hit)
+		})
+		if err != nil {
+			return n, err
+		}
+		if page.Hits < int64(f.size) {
+			return n, nil
+		}
+
+		// The page is full, fetch the rest of the window.
+		t, err := f.timestamp(last.Get("0"))
+		if err != nil {
+			return n, err
+		}
+		switch {
+		case f.tiebreaker != "":
+			after = json.RawMessage(last.Raw)
+		case t.After(from):
+			from, after = t, nil
+		default:
+			log.Warn().Msgf("more than %d documents at %s, skipping the rest of them. Set --tiebreaker to page through them", f.size, t.Format(time.RFC3339Nano))
+			after = json.RawMessage(last.Raw)
+		}
+	}
+}
+
+// timestamp returns the time of the time field sort value v. Elasticsearch
+// returns the sort values of dates in epoch milliseconds whatever the
+// format of the field, which --time-format only applies to the range.
+func (f *follower) timestamp(v gjson.Result) (time.Time, error) {
+	if v.Type != gjson.Number {
+		return time.Time{}, fmt.Errorf("unexpected sort value %s of %s", v.Raw, f.tf.Field)
+	}
+	return time.Unix(0, v.Int()*int64(time.Millisecond)), nil
+}
+
+// follow writes the documents of the search as they are indexed until ctx
+// is canceled.
+func follow(ctx context.Context, c *cli.Context, es *elasticsearch.Client, idx []string, opts []func(*esapi.SearchRequest), process hitProcessor, policy *errorPolicy) error {
+	switch {
+	case c.Bool("no-time-filter"):
+		return fmt.Errorf("--follow cannot be combined with --no-time-filter")
+	case c.String("checkpoint") != "":
+		return fmt.Errorf("--follow cannot be combined with --checkpoint")
+	case c.Int("slices") > 1:
+		return fmt.Errorf("--follow cannot be combined with --slices")
+	case c.Duration("interval") <= 0:
+		return fmt.Errorf("--interval must be positive")
+	}
+	format := c.String("output")
+	if !c.IsSet("output") {
+		format = "ndjson"
+	}
+	if format == "table" {
+		return fmt.Errorf("--follow cannot write the table output, which is aligned once complete")
+	}
+
+	since, _, err := timeRange(c)
+	if err != nil {
+		return err
+	}
+	tf, err := timeFilter(c, since, since)
+	if err != nil {
+		return err
+	}
+	query := func(tf *TimeFilter) ([]byte, error) {
+		r, err := filteredQuery(c, tf)
+		if err != nil {
+			return nil, err
+		}
+		if fields := c.StringSlice("fields"); len(fields) > 0 {
+			if r, err = withFields(r, fields); err != nil {
+				return nil, err
+			}
+		}
+		return ioutil.ReadAll(r)
+	}
+	var tiebreaker string
+	if tb := c.String("tiebreaker"); tb != "_shard_doc" {
+		tiebreaker = tb
+	}
+	fl := newFollower(es, idx, opts, tf, query, c.Int("size"), c.Duration("overlap"), tiebreaker)
+
+	var (
+		w    io.Writer = c.App.Writer
+		file *os.File
+	)
+	if name := c.String("output-file"); name != "" {
+		if file, err = os.Create(name); err != nil {
+			return err
+		}
+		// The file is flushed and closed on exit, this only cleans up
+		// after a failure.
+		defer file.Close()
+		w = bufio.NewWriter(file)
+	}
+	f, err := newFormatter(w, format, c.StringSlice("columns"))
+	if err != nil {
+		return err
+	}
+	flush := func() error {
+		if r, ok := f.(Resumable); ok {
+			if err := r.Flush(); err != nil {
+				return err
+			}
+		}
+		if buf, ok := w.(*bufio.Writer); ok {
+			return buf.Flush()
+		}
+		return nil
+	}
+
+	summary := make(amplitudeSummary)
+	handle := func(hit []byte) error {
+		h := gjson.ParseBytes(hit)
+		vs, err := process(h)
+		if err != nil {
+			return policy.Handle(h, err)
+		}
+		for _, v := range vs {
+			doc, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			if err := f.Write(doc); err != nil {
+				return err
+			}
+			if id, ok := v.(AmplitudeID); ok {
+				summary.Add(id)
+			}
+		}
+		return nil
+	}
+
+	ticker := time.NewTicker(c.Duration("interval"))
+	defer ticker.Stop()
+loop:
+	for {
+		n, err := fl.Poll(ctx, time.Now(), handle)
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+		log.Debug().Msgf("follow: %d new documents, latest: %s", n, fl.latest.Format(time.RFC3339Nano))
+		if err := flush(); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if file != nil {
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	if c.Bool("print") {
+		summary.Print(c.App.Writer)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/tidwall/gjson"
+)
+
+// fakeIndex serves the searches of a follower from in-memory documents.
+type fakeIndex struct {
+	mu   sync.Mutex
+	docs map[string]time.Time
+}
+
+func (x *fakeIndex) add(id string, t time.Time) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.docs[id] = t
+}
+
+func (x *fakeIndex) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var body struct {
+		Query struct {
+			Range map[string]struct {
+				Gte    string `json:"gte"`
+				Lte    string `json:"lte"`
+				Format string `json:"format"`
+			} `json:"range"`
+		} `json:"query"`
+		SearchAfter []int64 `json:"search_after"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rg := body.Query.Range["@timestamp"]
+	parse := func(s string) time.Time {
+		if rg.Format == "epoch_second" {
+			sec, _ := strconv.ParseInt(s, 10, 64)
+			return time.Unix(sec, 0)
+		}
+		t, _ := time.Parse(time.RFC3339Nano, s)
+		return t
+	}
+	gte, lte := parse(rg.Gte), parse(rg.Lte)
+	size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+	type doc struct {
+		id string
+		ms int64
+	}
+	var docs []doc
+	for id, t := range x.docs {
+		ms := t.UnixNano() / int64(time.Millisecond)
+		if t.Before(gte) || t.After(lte) || (len(body.SearchAfter) > 0 && ms <= body.SearchAfter[0]) {
+			continue
+		}
+		docs = append(docs, doc{id, ms})
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		if docs[i].ms != docs[j].ms {
+			return docs[i].ms < docs[j].ms
+		}
+		return docs[i].id < docs[j].id
+	})
+	if len(docs) > size {
+		docs = docs[:size]
+	}
+	hits := make([]map[string]interface{}, len(docs))
+	for i, d := range docs {
+		hits[i] = map[string]interface{}{"_index": "log", "_id": d.id, "sort": []int64{d.ms}}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"took": 1,
+		"hits": map[string]interface{}{"total": map[string]int{"value": len(docs)}, "hits": hits},
+	})
+}
+
+func TestFollowerPoll(t *testing.T) {
+	t.Parallel()
+	base := time.Date(2020, 12, 23, 13, 0, 0, 0, time.UTC)
+	at := func(s float64) time.Time { return base.Add(time.Duration(s * float64(time.Second))) }
+	type poll struct {
+		add  map[string]float64
+		now  float64
+		want []string
+	}
+	tests := []struct {
+		size   int
+		format string
+		polls  []poll
+	}{
+		{
+			size: 10,
+			polls: []poll{
+				{add: map[string]float64{"a": 1, "b": 2, "c": 3}, now: 10, want: []string{"a", "b", "c"}},
+				{add: nil, now: 20, want: nil},
+				// d is indexed late, within the overlap.
+				{add: map[string]float64{"d": 2.5, "e": 15}, now: 20, want: []string{"d", "e"}},
+				// f is newer than now.
+				{add: map[string]float64{"f": 40}, now: 30, want: nil},
+				{add: nil, now: 40, want: []string{"f"}},
+			},
+		},
+		{
+			size: 2,
+			polls: []poll{
+				{add: map[string]float64{"a": 1, "b": 2, "c": 2, "d": 3, "e": 4}, now: 10, want: []string{"a", "b", "c", "d", "e"}},
+			},
+		},
+		{
+			// More than size documents at the same time are skipped.
+			size: 2,
+			polls: []poll{
+				{add: map[string]float64{"a": 1, "b": 1, "c": 1, "d": 2}, now: 10, want: []string{"a", "b", "d"}},
+			},
+		},
+		{
+			// Documents before since are not output.
+			size: 10,
+			polls: []poll{
+				{add: map[string]float64{"a": -1, "b": 1}, now: 10, want: []string{"b"}},
+			},
+		},
+		{
+			// The sort values are epoch milliseconds whatever the format.
+			size:   10,
+			format: "epoch_second",
+			polls: []poll{
+				{add: map[string]float64{"a": 1, "b": 2}, now: 10, want: []string{"a", "b"}},
+				{add: map[string]float64{"c": 15}, now: 20, want: []string{"c"}},
+			},
+		},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			x := &fakeIndex{docs: make(map[string]time.Time)}
+			srv := httptest.NewServer(x)
+			defer srv.Close()
+			es, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{srv.URL}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			tf := &TimeFilter{Field: "@timestamp", Format: firstNonEmpty(tt.format, DefaultTimeFormat), Since: base, Until: base}
+			query := func(tf *TimeFilter) ([]byte, error) {
+				clause, err := tf.Clause()
+				if err != nil {
+					return nil, err
+				}
+				return json.Marshal(map[string]json.RawMessage{"query": clause})
+			}
+			opts := []func(*esapi.SearchRequest){es.Search.WithSize(tt.size)}
+			fl := newFollower(es, []string{"log"}, opts, tf, query, tt.size, 30*time.Second, "")
+			for j, p := range tt.polls {
+				for id, s := range p.add {
+					x.add(id, at(s))
+				}
+				var got []string
+				n, err := fl.Poll(context.Background(), at(p.now), func(hit []byte) error {
+					got = append(got, gjson.GetBytes(hit, "_id").Str)
+					return nil
+				})
+				if err != nil {
+					t.Fatalf("poll: %d err: %v", j, err)
+				}
+				if !reflect.DeepEqual(got, p.want) || n != len(p.want) {
+					t.Fatalf("poll: %d got: %v (%d) want: %v", j, got, n, p.want)
+				}
+			}
+		})
+	}
+}
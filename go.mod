@@ -1,6 +1,6 @@
 module github.com/lupinthe14th/escli
 
-go 1.14
+go 1.16
 
 require (
 	github.com/cheggaaa/pb/v3 v3.0.5
@@ -11,4 +11,6 @@ require (
 	github.com/tidwall/gjson v1.9.3
 	github.com/urfave/cli/v2 v2.3.0
 	golang.org/x/sys v0.0.0-20201223074533-0d417f636930 // indirect
+	golang.org/x/term v0.0.0-20201210144234-2321bbc49cbf
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+)
+
+// DefaultIndex is the index pattern used when neither --index nor the
+// current context specifies one.
+const DefaultIndex = "log-aws-waf-*"
+
+// ResolvedIndex wraps the Elasticsearch resolve index response.
+type ResolvedIndex struct {
+	Indices []struct {
+		Name string `json:"name"`
+	} `json:"indices"`
+	Aliases []struct {
+		Name string `json:"name"`
+	} `json:"aliases"`
+	DataStreams []struct {
+		Name string `json:"name"`
+	} `json:"data_streams"`
+}
+
+var indexFlag = &cli.StringSliceFlag{
+	Name:    "index",
+	Aliases: []string{"i"},
+	Usage:   "Index, alias or data stream to search. Accepts wildcards and comma separated lists, and may be repeated",
+	EnvVars: []string{"ESCLI_INDEX"},
+}
+
+// indices returns the indices to search, taken from the --index flag, the
+// current context or DefaultIndex in that order.
+func indices(c *cli.Context) ([]string, error) {
+	idx := splitIndices(c.StringSlice("index"))
+	if len(idx) == 0 {
+		ctx, err := currentContext(c)
+		if err != nil {
+			return nil, err
+		}
+		idx = splitIndices([]string{ctx.Index})
+	}
+	if len(idx) == 0 {
+		idx = []string{DefaultIndex}
+	}
+	log.Debug().Msgf("index: %s", strings.Join(idx, ","))
+	return idx, nil
+}
+
+// splitIndices flattens comma separated index lists and drops empty names.
+func splitIndices(values []string) []string {
+	idx := make([]string, 0, len(values))
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				idx = append(idx, s)
+			}
+		}
+	}
+	return idx
+}
+
+// resolveIndices checks that idx matches at least one index, alias or data stream.
+// The resolve index API requires the view_index_metadata privilege, so the
+// check is skipped for users only allowed to search.
+func resolveIndices(ctx context.Context, es *elasticsearch.Client, idx []string) error {
+	res, err := es.Indices.ResolveIndex(idx, es.Indices.ResolveIndex.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("Error getting response: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		log.Debug().Msgf("resolve: skipped, %s", res.Status())
+		return nil
+	}
+	if res.IsError() {
+		return responseError(res)
+	}
+
+	var r ResolvedIndex
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return fmt.Errorf("Error parsing the response body: %s", err)
+	}
+	log.Debug().Msgf("resolved: %d indices, %d aliases, %d data streams", len(r.Indices), len(r.Aliases), len(r.DataStreams))
+	if len(r.Indices)+len(r.Aliases)+len(r.DataStreams) == 0 {
+		return fmt.Errorf("no indices, aliases or data streams match %q", strings.Join(idx, ","))
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestSplitIndices(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   []string
+		want []string
+	}{
+		{in: nil, want: []string{}},
+		{in: []string{""}, want: []string{}},
+		{in: []string{"log-aws-waf-*"}, want: []string{"log-aws-waf-*"}},
+		{in: []string{"log-aws-waf-*,log-cloudfront-*", "app"}, want: []string{"log-aws-waf-*", "log-cloudfront-*", "app"}},
+		{in: []string{" a , ,b "}, want: []string{"a", "b"}},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			got := splitIndices(tt.in)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("in: %v got: %v want: %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveIndices(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in      int
+		body    string
+		wantErr bool
+	}{
+		{in: http.StatusOK, body: `{"indices":[{"name":"log-aws-waf-1"}],"aliases":[],"data_streams":[]}`, wantErr: false},
+		{in: http.StatusOK, body: `{"indices":[],"aliases":[],"data_streams":[]}`, wantErr: true},
+		// Users only allowed to search cannot resolve indices.
+		{in: http.StatusForbidden, body: `{"error":{"type":"security_exception","reason":"action [indices:admin/resolve/index] is unauthorized"},"status":403}`, wantErr: false},
+		{in: http.StatusUnauthorized, body: `{"error":{"type":"security_exception","reason":"missing authentication credentials"},"status":401}`, wantErr: false},
+		{in: http.StatusNotFound, body: `{"error":{"type":"index_not_found_exception","reason":"no such index [log]"},"status":404}`, wantErr: true},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.in)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer srv.Close()
+			es, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{srv.URL}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = resolveIndices(context.Background(), es, []string{"log"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("in: %v err: %v wantErr: %v", tt.in, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -29,40 +29,54 @@ type Info struct {
 	Tagline string `json:"tagline"`
 }
 
+// ClientInfo wraps the client version information.
+type ClientInfo struct {
+	Version       string `json:"version"`
+	GitCommit     string `json:"git_commit"`
+	Elasticsearch string `json:"elasticsearch"`
+}
+
+func newClientInfo() ClientInfo {
+	return ClientInfo{
+		Version:       version.Version,
+		GitCommit:     version.Revision,
+		Elasticsearch: elasticsearch.Version,
+	}
+}
+
 var infoCommand = &cli.Command{
 	Name:   "info",
 	Usage:  "Display system-wide information",
 	Action: infoAction,
+	Flags: []cli.Flag{
+		outputFlag("text", "text"),
+		columnsFlag,
+	},
 }
 
 func infoAction(c *cli.Context) error {
 	w := c.App.Writer
+	if format := c.String("output"); format != "text" {
+		info, err := getInfo(c)
+		if err != nil {
+			return err
+		}
+		return writeDocument(w, format, c.StringSlice("columns"), struct {
+			Client ClientInfo `json:"client"`
+			Server *Info      `json:"server"`
+		}{newClientInfo(), info})
+	}
+
 	fmt.Fprintf(w, "Client:\n")
 	fmt.Fprintf(w, " Version:\t%s\n", version.Version)
 	fmt.Fprintf(w, " Git commit:\t%s\n", version.Revision)
 	fmt.Fprintf(w, "Elasticsearch:\n")
 	fmt.Fprintf(w, " Version:\t%s\n", elasticsearch.Version)
 
-	es, err := newClient(c)
+	info, err := getInfo(c)
 	if err != nil {
 		return err
 	}
-	res, err := es.Info()
-	if err != nil {
-		return err
-	}
-	defer res.Body.Close()
-
-	// Check response status
-	if res.IsError() {
-		return fmt.Errorf("%s", res.String())
-	}
-
-	// Deserialize the response into a map.
-	var info Info
-	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
-		return err
-	}
 	fmt.Fprintf(w, "\n")
 	fmt.Fprintf(w, "Server:\n")
 	fmt.Fprintf(w, " Name:\t%s\n", info.Name)
@@ -81,3 +95,28 @@ func infoAction(c *cli.Context) error {
 	fmt.Fprintf(w, " Tagline:\t%s\n", info.Tagline)
 	return nil
 }
+
+// getInfo returns the Elasticsearch information.
+func getInfo(c *cli.Context) (*Info, error) {
+	es, err := newClient(c)
+	if err != nil {
+		return nil, err
+	}
+	res, err := es.Info()
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	// Check response status
+	if res.IsError() {
+		return nil, fmt.Errorf("%s", res.String())
+	}
+
+	// Deserialize the response into a map.
+	var info Info
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
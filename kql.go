@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// parseKQL translates a Kibana Query Language expression into a Query DSL
+// query. The supported subset is:
+//
+//	field:value            match, or match_phrase for a quoted value
+//	field:*                exists
+//	field:val*             wildcard
+//	field:(a or b)         the operators applied to the values of field
+//	field >= value         range, also with >, < and <=
+//	value                  multi_match over all fields
+//	a and b, a or b, not a boolean operators, case-insensitive
+//	( ... )                grouping
+//
+// Nested field queries (field:{ ... }) are not supported.
+func parseKQL(s string) (json.RawMessage, error) {
+	tokens, err := lexKQL(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &kqlParser{tokens: tokens}
+	if p.peek().kind == kqlEOF {
+		return json.Marshal(map[string]interface{}{"match_all": map[string]interface{}{}})
+	}
+	q, err := p.or("")
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != kqlEOF {
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+	return json.Marshal(q)
+}
+
+type kqlKind int
+
+const (
+	kqlEOF kqlKind = iota
+	kqlWord
+	kqlQuoted
+	kqlLParen
+	kqlRParen
+	kqlColon
+	kqlRange
+	kqlAnd
+	kqlOr
+	kqlNot
+)
+
+type kqlToken struct {
+	kind kqlKind
+	// text is the unescaped text of words and quoted strings and the operator
+	// of ranges.
+	text string
+	// pattern is the text of a word as a wildcard pattern.
+	pattern  string
+	wildcard bool
+	pos      int
+}
+
+func (t kqlToken) String() string {
+	switch t.kind {
+	case kqlEOF:
+		return "end of query"
+	case kqlQuoted:
+		return fmt.Sprintf("%q", t.text)
+	case kqlLParen:
+		return `"("`
+	case kqlRParen:
+		return `")"`
+	case kqlColon:
+		return `":"`
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+func lexKQL(s string) ([]kqlToken, error) {
+	var tokens []kqlToken
+	r := []rune(s)
+	for i := 0; i < len(r); {
+		switch c := r[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, kqlToken{kind: kqlLParen, pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, kqlToken{kind: kqlRParen, pos: i})
+			i++
+		case c == ':':
+			tokens = append(tokens, kqlToken{kind: kqlColon, pos: i})
+			i++
+		case c == '<' || c == '>':
+			op := string(c)
+			if i+1 < len(r) && r[i+1] == '=' {
+				op += "="
+			}
+			tokens = append(tokens, kqlToken{kind: kqlRange, text: op, pos: i})
+			i += len(op)
+		case c == '{' || c == '}':
+			return nil, fmt.Errorf("KQL syntax error at %d: nested field queries are not supported", i)
+		case c == '"':
+			var b strings.Builder
+			start := i
+			for i++; ; i++ {
+				if i >= len(r) {
+					return nil, fmt.Errorf("KQL syntax error at %d: unterminated quoted string", start)
+				}
+				if r[i] == '\\' && i+1 < len(r) {
+					i++
+				} else if r[i] == '"' {
+					break
+				}
+				b.WriteRune(r[i])
+			}
+			i++
+			tokens = append(tokens, kqlToken{kind: kqlQuoted, text: b.String(), pos: start})
+		default:
+			var text, pattern strings.Builder
+			t := kqlToken{kind: kqlWord, pos: i}
+			escaped := false
+		word:
+			for ; i < len(r); i++ {
+				switch c := r[i]; c {
+				case ' ', '\t', '\n', '\r', '(', ')', ':', '<', '>', '"', '{', '}':
+					break word
+				case '\\':
+					if i+1 == len(r) {
+						return nil, fmt.Errorf("KQL syntax error at %d: escape at end of query", i)
+					}
+					i++
+					escaped = true
+					text.WriteRune(r[i])
+					if r[i] == '*' || r[i] == '?' || r[i] == '\\' {
+						pattern.WriteRune('\\')
+					}
+					pattern.WriteRune(r[i])
+				case '*':
+					t.wildcard = true
+					text.WriteRune(c)
+					pattern.WriteRune(c)
+				case '?':
+					text.WriteRune(c)
+					pattern.WriteString(`\?`)
+				default:
+					text.WriteRune(c)
+					pattern.WriteRune(c)
+				}
+			}
+			t.text, t.pattern = text.String(), pattern.String()
+			if !t.wildcard && !escaped {
+				switch strings.ToLower(t.text) {
+				case "and":
+					t.kind = kqlAnd
+				case "or":
+					t.kind = kqlOr
+				case "not":
+					t.kind = kqlNot
+				}
+			}
+			tokens = append(tokens, t)
+		}
+	}
+	return append(tokens, kqlToken{kind: kqlEOF, pos: len(r)}), nil
+}
+
+type kqlParser struct {
+	tokens []kqlToken
+	pos    int
+}
+
+func (p *kqlParser) peek() kqlToken { return p.tokens[p.pos] }
+
+func (p *kqlParser) next() kqlToken {
+	t := p.tokens[p.pos]
+	if t.kind != kqlEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *kqlParser) errorf(t kqlToken, format string, args ...interface{}) error {
+	return fmt.Errorf("KQL syntax error at %d: %s", t.pos, fmt.Sprintf(format, args...))
+}
+
+// or parses a disjunction. field is the field the values apply to inside
+// field:( ... ), and "" otherwise.
+func (p *kqlParser) or(field string) (interface{}, error) {
+	q, err := p.and(field)
+	if err != nil {
+		return nil, err
+	}
+	should := []interface{}{q}
+	for p.peek().kind == kqlOr {
+		p.next()
+		q, err := p.and(field)
+		if err != nil {
+			return nil, err
+		}
+		should = append(should, q)
+	}
+	if len(should) == 1 {
+		return should[0], nil
+	}
+	return boolQuery("should", should, 1), nil
+}
+
+func (p *kqlParser) and(field string) (interface{}, error) {
+	q, err := p.not(field)
+	if err != nil {
+		return nil, err
+	}
+	filter := []interface{}{q}
+	for p.peek().kind == kqlAnd {
+		p.next()
+		q, err := p.not(field)
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, q)
+	}
+	if len(filter) == 1 {
+		return filter[0], nil
+	}
+	return boolQuery("filter", filter, 0), nil
+}
+
+func (p *kqlParser) not(field string) (interface{}, error) {
+	if p.peek().kind != kqlNot {
+		return p.primary(field)
+	}
+	p.next()
+	q, err := p.not(field)
+	if err != nil {
+		return nil, err
+	}
+	return boolQuery("must_not", []interface{}{q}, 0), nil
+}
+
+func (p *kqlParser) primary(field string) (interface{}, error) {
+	t := p.next()
+	switch t.kind {
+	case kqlLParen:
+		q, err := p.or(field)
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != kqlRParen {
+			return nil, p.errorf(t, `expected ")" but got %s`, t)
+		}
+		return q, nil
+	case kqlWord, kqlQuoted:
+	default:
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+	if field != "" {
+		return kqlValue(field, t), nil
+	}
+	switch p.peek().kind {
+	case kqlColon:
+		if t.kind != kqlWord {
+			return nil, p.errorf(t, "field name must not be quoted")
+		}
+		p.next()
+		if p.peek().kind == kqlLParen {
+			p.next()
+			q, err := p.or(t.text)
+			if err != nil {
+				return nil, err
+			}
+			if r := p.next(); r.kind != kqlRParen {
+				return nil, p.errorf(r, `expected ")" but got %s`, r)
+			}
+			return q, nil
+		}
+		v := p.next()
+		if v.kind != kqlWord && v.kind != kqlQuoted {
+			return nil, p.errorf(v, "expected a value for %s but got %s", t.text, v)
+		}
+		return kqlValue(t.text, v), nil
+	case kqlRange:
+		if t.kind != kqlWord {
+			return nil, p.errorf(t, "field name must not be quoted")
+		}
+		op := p.next()
+		v := p.next()
+		if v.kind != kqlWord && v.kind != kqlQuoted {
+			return nil, p.errorf(v, "expected a value for %s but got %s", t.text, v)
+		}
+		key := map[string]string{"<": "lt", "<=": "lte", ">": "gt", ">=": "gte"}[op.text]
+		return map[string]interface{}{
+			"range": map[string]interface{}{t.text: map[string]interface{}{key: v.text}},
+		}, nil
+	}
+	// A value without a field searches all fields.
+	switch {
+	case t.kind == kqlQuoted:
+		return map[string]interface{}{
+			"multi_match": map[string]interface{}{"query": t.text, "type": "phrase", "lenient": true},
+		}, nil
+	case t.wildcard:
+		return map[string]interface{}{
+			"query_string": map[string]interface{}{"query": queryStringEscape(t.pattern)},
+		}, nil
+	}
+	return map[string]interface{}{
+		"multi_match": map[string]interface{}{"query": t.text, "type": "best_fields", "lenient": true},
+	}, nil
+}
+
+// kqlValue returns the query matching value v of field.
+func kqlValue(field string, v kqlToken) interface{} {
+	switch {
+	case v.kind == kqlQuoted:
+		return map[string]interface{}{"match_phrase": map[string]interface{}{field: v.text}}
+	case v.text == "*":
+		return map[string]interface{}{"exists": map[string]interface{}{"field": field}}
+	case v.wildcard:
+		return map[string]interface{}{"wildcard": map[string]interface{}{field: map[string]interface{}{"value": v.pattern}}}
+	}
+	return map[string]interface{}{"match": map[string]interface{}{field: v.text}}
+}
+
+func boolQuery(occur string, clauses []interface{}, minimumShouldMatch int) interface{} {
+	b := map[string]interface{}{occur: clauses}
+	if minimumShouldMatch > 0 {
+		b["minimum_should_match"] = minimumShouldMatch
+	}
+	return map[string]interface{}{"bool": b}
+}
+
+// queryStringEscape escapes the query_string syntax of the wildcard pattern p
+// but the wildcards and the escapes of p.
+func queryStringEscape(p string) string {
+	var b strings.Builder
+	r := []rune(p)
+	for i := 0; i < len(r); i++ {
+		switch c := r[i]; {
+		case c == '\\' && i+1 < len(r):
+			b.WriteRune(c)
+			i++
+			b.WriteRune(r[i])
+			continue
+		case c != '*' && strings.ContainsRune(`+-=&|><!(){}[]^"~?:\\/`, c):
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r[i])
+	}
+	return b.String()
+}
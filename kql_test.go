@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseKQL(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "", want: `{"match_all":{}}`, wantErr: false},
+		{in: "action:BLOCK", want: `{"match":{"action":"BLOCK"}}`, wantErr: false},
+		{in: `httpRequest.uri:"/login php"`, want: `{"match_phrase":{"httpRequest.uri":"/login php"}}`, wantErr: false},
+		{in: "httpRequest.clientIp:1.2.3.4 and action:BLOCK", want: `{"bool":{"filter":[{"match":{"httpRequest.clientIp":"1.2.3.4"}},{"match":{"action":"BLOCK"}}]}}`, wantErr: false},
+		{in: "action:BLOCK OR action:COUNT", want: `{"bool":{"minimum_should_match":1,"should":[{"match":{"action":"BLOCK"}},{"match":{"action":"COUNT"}}]}}`, wantErr: false},
+		{in: "a:1 or b:2 and c:3", want: `{"bool":{"minimum_should_match":1,"should":[{"match":{"a":"1"}},{"bool":{"filter":[{"match":{"b":"2"}},{"match":{"c":"3"}}]}}]}}`, wantErr: false},
+		{in: "(a:1 or b:2) and c:3", want: `{"bool":{"filter":[{"bool":{"minimum_should_match":1,"should":[{"match":{"a":"1"}},{"match":{"b":"2"}}]}},{"match":{"c":"3"}}]}}`, wantErr: false},
+		{in: "not action:ALLOW", want: `{"bool":{"must_not":[{"match":{"action":"ALLOW"}}]}}`, wantErr: false},
+		{in: "action:(BLOCK or COUNT)", want: `{"bool":{"minimum_should_match":1,"should":[{"match":{"action":"BLOCK"}},{"match":{"action":"COUNT"}}]}}`, wantErr: false},
+		{in: "labels:*", want: `{"exists":{"field":"labels"}}`, wantErr: false},
+		{in: "terminatingRuleId:AWS-*", want: `{"wildcard":{"terminatingRuleId":{"value":"AWS-*"}}}`, wantErr: false},
+		{in: `uri:a\*b?`, want: `{"match":{"uri":"a*b?"}}`, wantErr: false},
+		{in: `uri:a\*b*`, want: `{"wildcard":{"uri":{"value":"a\\*b*"}}}`, wantErr: false},
+		{in: "bytes >= 1024", want: `{"range":{"bytes":{"gte":"1024"}}}`, wantErr: false},
+		{in: "bytes<10", want: `{"range":{"bytes":{"lt":"10"}}}`, wantErr: false},
+		{in: "BLOCK", want: `{"multi_match":{"lenient":true,"query":"BLOCK","type":"best_fields"}}`, wantErr: false},
+		{in: `"sql injection"`, want: `{"multi_match":{"lenient":true,"query":"sql injection","type":"phrase"}}`, wantErr: false},
+		{in: "/admin*", want: `{"query_string":{"query":"\\/admin*"}}`, wantErr: false},
+		{in: `action:\and`, want: `{"match":{"action":"and"}}`, wantErr: false},
+		{in: "action:", wantErr: true},
+		{in: "(action:BLOCK", wantErr: true},
+		{in: "action:BLOCK)", wantErr: true},
+		{in: "action:BLOCK and", wantErr: true},
+		{in: `action:"BLOCK`, wantErr: true},
+		{in: `"action":BLOCK`, wantErr: true},
+		{in: "user:{ name:a }", wantErr: true},
+		{in: `a\`, wantErr: true},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			got, err := parseKQL(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("in: %v err: %v wantErr: %v", tt.in, err, tt.wantErr)
+			}
+			if err == nil && string(got) != tt.want {
+				t.Fatalf("in: %v got: %s want: %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -12,7 +12,7 @@ import (
 
 func main() {
 	if err := newApp().Run(os.Args); err != nil {
-		log.Fatal().Err(err)
+		log.Fatal().Err(err).Msg("")
 	}
 }
 
@@ -22,6 +22,7 @@ func newApp() *cli.App {
 	app.Name = "escli"
 	app.Usage = "elasticsearch service client by golang"
 	app.UseShortOptionHandling = true
+	app.EnableBashCompletion = true
 	app.Version = strings.TrimPrefix(version.Version, "v")
 	app.Flags = []cli.Flag{
 		&cli.BoolFlag{
@@ -29,6 +30,23 @@ func newApp() *cli.App {
 			Usage:       "debug mode",
 			Destination: &debug,
 		},
+		&cli.StringFlag{
+			Name:    "config",
+			Usage:   "Path to the config file",
+			EnvVars: []string{"ESCLI_CONFIG"},
+			Value:   defaultConfigPath(),
+		},
+		&cli.StringFlag{
+			Name:    "context",
+			Usage:   "Context of the config file to use instead of the current context",
+			EnvVars: []string{"ESCLI_CONTEXT"},
+		},
+		&cli.StringFlag{
+			Name:    "rules-dir",
+			Usage:   "Directory of the user rule definitions",
+			EnvVars: []string{"ESCLI_RULES_DIR"},
+			Value:   defaultRulesDir(),
+		},
 		&cli.StringFlag{
 			Name:    "address",
 			Aliases: []string{"a", "host", "H", "url", "URL"},
@@ -39,16 +57,79 @@ func newApp() *cli.App {
 		&cli.StringFlag{
 			Name:    "username",
 			Aliases: []string{"u"},
-			Usage:   "Elasticsearch username",
+			Usage:   "Elasticsearch username. The password is prompted for when not given and stdin is a terminal",
 			EnvVars: []string{"ELASTICSEARCH_USERNAME"},
-			Value:   "elasticsearch",
 		},
 		&cli.StringFlag{
 			Name:    "password",
 			Aliases: []string{"p"},
-			Usage:   "Elasticsearch url",
+			Usage:   "Elasticsearch password",
 			EnvVars: []string{"ELASTICSEARCH_PASSWORD"},
-			Value:   "secret",
+		},
+		&cli.BoolFlag{
+			Name:  "password-stdin",
+			Usage: "Read the password from stdin",
+		},
+		&cli.StringFlag{
+			Name:    "password-file",
+			Usage:   "Read the password from a file",
+			EnvVars: []string{"ELASTICSEARCH_PASSWORD_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    "cacert",
+			Usage:   "PEM file of the certificate authorities to trust in addition to the system ones",
+			EnvVars: []string{"ELASTICSEARCH_CACERT"},
+		},
+		&cli.StringFlag{
+			Name:    "cert",
+			Usage:   "PEM file of the client certificate for mutual TLS",
+			EnvVars: []string{"ELASTICSEARCH_CERT"},
+		},
+		&cli.StringFlag{
+			Name:    "key",
+			Usage:   "PEM file of the key of the client certificate",
+			EnvVars: []string{"ELASTICSEARCH_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "ca-fingerprint",
+			Usage:   "SHA-256 fingerprint of the CA certificate of the server to trust, as printed by Elasticsearch at setup",
+			EnvVars: []string{"ELASTICSEARCH_CA_FINGERPRINT"},
+		},
+		&cli.BoolFlag{
+			Name:  "insecure-skip-verify",
+			Usage: "Do not verify the certificate of the server. Insecure, for testing only",
+		},
+		&cli.BoolFlag{
+			Name:    "aws-sigv4",
+			Usage:   "Sign the requests with AWS Signature Version 4 for Amazon OpenSearch Service. The credentials are read from the AWS environment variables or the shared credentials and config files",
+			EnvVars: []string{"ESCLI_AWS_SIGV4"},
+		},
+		&cli.StringFlag{
+			Name:  "aws-region",
+			Usage: "AWS region of the domain. Defaults to AWS_REGION, AWS_DEFAULT_REGION or the region of the profile",
+		},
+		&cli.StringFlag{
+			Name:  "aws-service",
+			Usage: "AWS service signing name, es for managed domains or aoss for OpenSearch Serverless (default: es)",
+		},
+		&cli.StringFlag{
+			Name:  "aws-profile",
+			Usage: "AWS profile of the shared credentials and config files. Defaults to AWS_PROFILE or default",
+		},
+		&cli.StringFlag{
+			Name:    "api-key",
+			Usage:   "Elasticsearch API key, as id:key or encoded",
+			EnvVars: []string{"ELASTICSEARCH_API_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "service-token",
+			Usage:   "Elasticsearch service account token",
+			EnvVars: []string{"ELASTICSEARCH_SERVICE_TOKEN"},
+		},
+		&cli.StringFlag{
+			Name:    "bearer-token",
+			Usage:   "OAuth2 or JWT bearer token",
+			EnvVars: []string{"ELASTICSEARCH_BEARER_TOKEN"},
 		},
 	}
 	app.Before = func(c *cli.Context) error {
@@ -62,6 +143,10 @@ func newApp() *cli.App {
 
 	app.Commands = []*cli.Command{
 		searchCommand,
+		countCommand,
+		aggCommand,
+		rulesCommand,
+		configCommand,
 		// System
 		infoCommand,
 		versionCommand,
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tidwall/gjson"
+)
+
+// Quarantined is a record of the quarantine file.
+type Quarantined struct {
+	Index  string `json:"_index"`
+	ID     string `json:"_id"`
+	Source string `json:"source,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Error  string `json:"error"`
+}
+
+// errorPolicy handles the hits that fail to be processed.
+// It is safe for concurrent use.
+type errorPolicy struct {
+	mode       string
+	quarantine string
+
+	mu      sync.Mutex
+	skipped int64
+	errs    map[string]int64
+	file    *os.File
+	buf     *bufio.Writer
+}
+
+// newErrorPolicy returns the policy mode recording the skipped hits to the
+// quarantine file once opened.
+func newErrorPolicy(mode, quarantine string) (*errorPolicy, error) {
+	switch mode {
+	case "fail":
+		if quarantine != "" {
+			return nil, fmt.Errorf("--quarantine requires --on-error skip or collect")
+		}
+	case "skip", "collect":
+	default:
+		return nil, fmt.Errorf("unknown --on-error %q, want one of fail, skip or collect", mode)
+	}
+	return &errorPolicy{mode: mode, quarantine: quarantine, errs: make(map[string]int64)}, nil
+}
+
+// Open opens the quarantine file. A resumed search appends to the hits
+// quarantined before it was interrupted, a new one overwrites the file. The
+// hits quarantined after the last checkpoint are recorded again on resume.
+func (p *errorPolicy) Open(resume bool) error {
+	if p.quarantine == "" {
+		return nil
+	}
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resume {
+		flag = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(p.quarantine, flag, 0644)
+	if err != nil {
+		return err
+	}
+	p.file, p.buf = file, bufio.NewWriter(file)
+	return nil
+}
+
+// Handle returns err when the policy is fail. Otherwise it counts the hit as
+// skipped, records it to the quarantine file and returns nil.
+func (p *errorPolicy) Handle(hit gjson.Result, err error) error {
+	if p.mode == "fail" {
+		return err
+	}
+	q := Quarantined{
+		Index: hit.Get("_index").Str,
+		ID:    hit.Get("_id").Str,
+		Error: err.Error(),
+	}
+	key := err.Error()
+	var xerr *ExtractError
+	if errors.As(err, &xerr) {
+		q.Source, q.Name, q.Value = xerr.Source, xerr.Name, xerr.Value
+		// Group by the failure, not by the value.
+		key = fmt.Sprintf("%s %s as %s: %s", xerr.Source, xerr.Name, xerr.Decoder, xerr.Err)
+	}
+	log.Debug().Msgf("skipped %s/%s: %s", q.Index, q.ID, err)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.skipped++
+	if p.mode == "collect" {
+		p.errs[key]++
+	}
+	if p.buf == nil {
+		return nil
+	}
+	b, merr := json.Marshal(q)
+	if merr != nil {
+		return merr
+	}
+	_, werr := fmt.Fprintf(p.buf, "%s\n", b)
+	return werr
+}
+
+// Skipped returns the number of the skipped hits.
+func (p *errorPolicy) Skipped() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.skipped
+}
+
+// Report logs the number of the skipped hits and, when collecting, the
+// errors in descending order of their counts.
+func (p *errorPolicy) Report() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.skipped == 0 {
+		return
+	}
+	log.Warn().Msgf("skipped %d hits that could not be processed", p.skipped)
+	keys := make([]string, 0, len(p.errs))
+	for k := range p.errs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if p.errs[keys[i]] != p.errs[keys[j]] {
+			return p.errs[keys[i]] > p.errs[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	for _, k := range keys {
+		log.Warn().Msgf("%d: %s", p.errs[k], k)
+	}
+	if p.file != nil {
+		log.Warn().Msgf("quarantined to %s", p.file.Name())
+	}
+}
+
+// Close writes and closes the quarantine file.
+func (p *errorPolicy) Close() error {
+	if p.file == nil {
+		return nil
+	}
+	if err := p.buf.Flush(); err != nil {
+		p.file.Close()
+		return err
+	}
+	return p.file.Close()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestNewErrorPolicy(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		mode, quarantine string
+		wantErr          bool
+	}{
+		{mode: "fail", quarantine: "", wantErr: false},
+		{mode: "skip", quarantine: "", wantErr: false},
+		{mode: "collect", quarantine: "", wantErr: false},
+		{mode: "fail", quarantine: "q.ndjson", wantErr: true},
+		{mode: "ignore", quarantine: "", wantErr: true},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			_, err := newErrorPolicy(tt.mode, tt.quarantine)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("in: %v %v err: %v wantErr: %v", tt.mode, tt.quarantine, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestErrorPolicyHandle(t *testing.T) {
+	t.Parallel()
+	hit := gjson.Parse(`{"_index":"waf","_id":"1"}`)
+	xerr := &ExtractError{Source: "cookie", Name: "amplitude_id_x", Decoder: "base64", Value: "!!", Err: errors.New("illegal base64 data at input byte 0")}
+	tests := []struct {
+		mode        string
+		errs        []error
+		wantErr     bool
+		wantSkipped int64
+		wantFile    string
+	}{
+		{mode: "fail", errs: []error{xerr}, wantErr: true, wantSkipped: 0, wantFile: ""},
+		{mode: "skip", errs: []error{xerr, xerr}, wantErr: false, wantSkipped: 2,
+			wantFile: `{"_index":"waf","_id":"1","source":"cookie","name":"amplitude_id_x","value":"!!","error":"Error decoding the cookie amplitude_id_x as base64: illegal base64 data at input byte 0"}` + "\n" +
+				`{"_index":"waf","_id":"1","source":"cookie","name":"amplitude_id_x","value":"!!","error":"Error decoding the cookie amplitude_id_x as base64: illegal base64 data at input byte 0"}` + "\n"},
+		{mode: "collect", errs: []error{errors.New("boom")}, wantErr: false, wantSkipped: 1,
+			wantFile: `{"_index":"waf","_id":"1","error":"boom"}` + "\n"},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			var name string
+			if tt.mode != "fail" {
+				name = filepath.Join(t.TempDir(), "quarantine.ndjson")
+			}
+			p, err := newErrorPolicy(tt.mode, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := p.Open(false); err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range tt.errs {
+				if err := p.Handle(hit, e); (err != nil) != tt.wantErr {
+					t.Fatalf("in: %v err: %v wantErr: %v", tt.mode, err, tt.wantErr)
+				}
+			}
+			if got := p.Skipped(); got != tt.wantSkipped {
+				t.Fatalf("in: %v got: %v want: %v", tt.mode, got, tt.wantSkipped)
+			}
+			if err := p.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if name == "" {
+				return
+			}
+			b, err := ioutil.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(b); got != tt.wantFile {
+				t.Fatalf("in: %v got: %v want: %v", tt.mode, got, tt.wantFile)
+			}
+		})
+	}
+}
+
+func TestErrorPolicyOpen(t *testing.T) {
+	t.Parallel()
+	hit := gjson.Parse(`{"_index":"waf","_id":"2"}`)
+	record := `{"_index":"waf","_id":"1","error":"boom"}` + "\n"
+	tests := []struct {
+		resume bool
+		want   string
+	}{
+		{resume: false, want: `{"_index":"waf","_id":"2","error":"boom"}` + "\n"},
+		{resume: true, want: record + `{"_index":"waf","_id":"2","error":"boom"}` + "\n"},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			name := filepath.Join(t.TempDir(), "quarantine.ndjson")
+			if err := ioutil.WriteFile(name, []byte(record), 0644); err != nil {
+				t.Fatal(err)
+			}
+			p, err := newErrorPolicy("skip", name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := p.Open(tt.resume); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.Handle(hit, errors.New("boom")); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.Close(); err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(b); got != tt.want {
+				t.Fatalf("in: %v got: %v want: %v", tt.resume, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tidwall/gjson"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+)
+
+// Formatter writes JSON documents to an output stream.
+type Formatter interface {
+	// Write writes a single JSON document.
+	Write(doc []byte) error
+	// Close writes any buffered output and the trailer of the format.
+	Close() error
+}
+
+// Resumable is implemented by the Formatters whose output can be appended to
+// by a later run.
+type Resumable interface {
+	Formatter
+	// Flush writes any buffered output.
+	Flush() error
+	// Resume continues an output whose header was written for columns.
+	Resume(columns []string)
+	// Columns returns the columns of the header, if any.
+	Columns() []string
+}
+
+// Formats is the list of the supported output formats.
+var Formats = []string{"json", "ndjson", "csv", "tsv", "table", "yaml"}
+
+func outputFlag(value string, extra ...string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:    "output",
+		Aliases: []string{"o"},
+		Usage:   fmt.Sprintf("Output format (%s)", strings.Join(append(extra, Formats...), ", ")),
+		Value:   value,
+	}
+}
+
+var columnsFlag = &cli.StringSliceFlag{
+	Name:  "columns",
+	Usage: "Columns of the csv, tsv and table output as gjson paths. Defaults to the fields of the first document",
+}
+
+// newFormatter returns the Formatter for format writing to w.
+// columns are gjson paths selecting the values of the tabular formats.
+func newFormatter(w io.Writer, format string, columns []string) (Formatter, error) {
+	switch format {
+	case "json":
+		return &jsonFormatter{w: w}, nil
+	case "ndjson":
+		return &ndjsonFormatter{w: w}, nil
+	case "csv":
+		return &csvFormatter{w: csv.NewWriter(w), columns: columns}, nil
+	case "tsv":
+		cw := csv.NewWriter(w)
+		cw.Comma = '\t'
+		return &csvFormatter{w: cw, columns: columns}, nil
+	case "table":
+		return &tableFormatter{w: tabwriter.NewWriter(w, 0, 8, 2, ' ', 0), columns: columns}, nil
+	case "yaml":
+		return &yamlFormatter{w: w}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+// jsonFormatter writes the documents as a JSON array.
+type jsonFormatter struct {
+	w io.Writer
+	n int
+}
+
+func (f *jsonFormatter) Write(doc []byte) error {
+	sep := ","
+	if f.n == 0 {
+		sep = "["
+	}
+	f.n++
+	_, err := fmt.Fprintf(f.w, "%s%s", sep, doc)
+	return err
+}
+
+func (f *jsonFormatter) Close() error {
+	if f.n == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintf(f.w, "]\n")
+	return err
+}
+
+// ndjsonFormatter writes one document per line.
+type ndjsonFormatter struct {
+	w io.Writer
+}
+
+func (f *ndjsonFormatter) Write(doc []byte) error {
+	_, err := fmt.Fprintf(f.w, "%s\n", doc)
+	return err
+}
+
+func (f *ndjsonFormatter) Close() error { return nil }
+
+func (f *ndjsonFormatter) Flush() error { return nil }
+
+func (f *ndjsonFormatter) Resume(columns []string) {}
+
+func (f *ndjsonFormatter) Columns() []string { return nil }
+
+// csvFormatter writes a header and one record per document.
+type csvFormatter struct {
+	w       *csv.Writer
+	columns []string
+	header  bool
+}
+
+func (f *csvFormatter) Write(doc []byte) error {
+	if !f.header {
+		if len(f.columns) == 0 {
+			f.columns = columnsOf(doc)
+		}
+		header := make([]string, len(f.columns))
+		for i, c := range f.columns {
+			header[i] = columnName(c)
+		}
+		if err := f.w.Write(header); err != nil {
+			return err
+		}
+		f.header = true
+	}
+	return f.w.Write(row(doc, f.columns))
+}
+
+func (f *csvFormatter) Close() error {
+	return f.Flush()
+}
+
+func (f *csvFormatter) Flush() error {
+	f.w.Flush()
+	return f.w.Error()
+}
+
+func (f *csvFormatter) Resume(columns []string) {
+	f.columns = columns
+	f.header = true
+}
+
+func (f *csvFormatter) Columns() []string { return f.columns }
+
+// tableFormatter writes the documents as aligned columns.
+// The table is buffered until Close to compute the column widths.
+type tableFormatter struct {
+	w       *tabwriter.Writer
+	columns []string
+	header  bool
+}
+
+func (f *tableFormatter) Write(doc []byte) error {
+	if !f.header {
+		if len(f.columns) == 0 {
+			f.columns = columnsOf(doc)
+		}
+		header := make([]string, len(f.columns))
+		for i, c := range f.columns {
+			header[i] = strings.ToUpper(columnName(c))
+		}
+		if _, err := fmt.Fprintln(f.w, strings.Join(header, "\t")); err != nil {
+			return err
+		}
+		f.header = true
+	}
+	_, err := fmt.Fprintln(f.w, strings.Join(row(doc, f.columns), "\t"))
+	return err
+}
+
+func (f *tableFormatter) Close() error {
+	return f.w.Flush()
+}
+
+// yamlFormatter writes one YAML document per document.
+type yamlFormatter struct {
+	w io.Writer
+}
+
+func (f *yamlFormatter) Write(doc []byte) error {
+	out, err := yaml.Marshal(toYAML(gjson.ParseBytes(doc)))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f.w, "---\n%s", out)
+	return err
+}
+
+func (f *yamlFormatter) Close() error { return nil }
+
+func (f *yamlFormatter) Flush() error { return nil }
+
+func (f *yamlFormatter) Resume(columns []string) {}
+
+func (f *yamlFormatter) Columns() []string { return nil }
+
+// columnsOf returns the gjson paths of the leaf values of doc in document order.
+// Arrays are treated as leaf values.
+func columnsOf(doc []byte) []string {
+	var columns []string
+	var walk func(prefix string, r gjson.Result)
+	walk = func(prefix string, r gjson.Result) {
+		if !r.IsObject() {
+			columns = append(columns, prefix)
+			return
+		}
+		r.ForEach(func(k, v gjson.Result) bool {
+			key := escapePath(k.String())
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			walk(key, v)
+			return true
+		})
+	}
+	walk("", gjson.ParseBytes(doc))
+	return columns
+}
+
+// escapePath escapes the gjson path characters of a key.
+func escapePath(key string) string {
+	r := strings.NewReplacer(".", `\.`, "*", `\*`, "?", `\?`)
+	return r.Replace(key)
+}
+
+// columnName returns the header of the column c, c without the escapes of
+// escapePath.
+func columnName(c string) string {
+	r := strings.NewReplacer(`\.`, ".", `\*`, "*", `\?`, "?")
+	return r.Replace(c)
+}
+
+// row returns the values of columns in doc.
+func row(doc []byte, columns []string) []string {
+	values := make([]string, len(columns))
+	for i, c := range columns {
+		v := gjson.GetBytes(doc, c)
+		switch v.Type {
+		case gjson.String:
+			values[i] = v.Str
+		case gjson.Null:
+			values[i] = ""
+		default:
+			values[i] = v.Raw
+		}
+	}
+	return values
+}
+
+// toYAML converts r into a value that marshals to YAML in document order.
+func toYAML(r gjson.Result) interface{} {
+	switch {
+	case r.IsObject():
+		m := yaml.MapSlice{}
+		r.ForEach(func(k, v gjson.Result) bool {
+			m = append(m, yaml.MapItem{Key: k.String(), Value: toYAML(v)})
+			return true
+		})
+		return m
+	case r.IsArray():
+		a := []interface{}{}
+		for _, v := range r.Array() {
+			a = append(a, toYAML(v))
+		}
+		return a
+	}
+	switch r.Type {
+	case gjson.String:
+		return r.Str
+	case gjson.Number:
+		return json.Number(r.Raw)
+	case gjson.True, gjson.False:
+		return r.Bool()
+	default:
+		return nil
+	}
+}
+
+// writeDocument writes v as a single document in format.
+// The json format writes an indented object instead of an array.
+func writeDocument(w io.Writer, format string, columns []string, v interface{}) error {
+	if format == "json" {
+		out, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", out)
+		return err
+	}
+	f, err := newFormatter(w, format, columns)
+	if err != nil {
+		return err
+	}
+	doc, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := f.Write(doc); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestFormatter(t *testing.T) {
+	t.Parallel()
+	docs := []string{
+		`{"_id":"1","_source":{"action":"BLOCK","httpRequest":{"clientIp":"192.0.2.1"}}}`,
+		`{"_id":"2","_source":{"action":"ALLOW, COUNT","httpRequest":{"clientIp":"192.0.2.2"}}}`,
+	}
+	tests := []struct {
+		format  string
+		columns []string
+		want    string
+		wantErr bool
+	}{
+		{format: "json", want: `[` + docs[0] + `,` + docs[1] + "]\n"},
+		{format: "ndjson", want: docs[0] + "\n" + docs[1] + "\n"},
+		{format: "csv", want: "_id,_source.action,_source.httpRequest.clientIp\n1,BLOCK,192.0.2.1\n2,\"ALLOW, COUNT\",192.0.2.2\n"},
+		{format: "tsv", columns: []string{"_source.httpRequest.clientIp", "_id"}, want: "_source.httpRequest.clientIp\t_id\n192.0.2.1\t1\n192.0.2.2\t2\n"},
+		{format: "table", columns: []string{"_id", "_source.action"}, want: "_ID  _SOURCE.ACTION\n1    BLOCK\n2    ALLOW, COUNT\n"},
+		{format: "yaml", want: "---\n_id: \"1\"\n_source:\n  action: BLOCK\n  httpRequest:\n    clientIp: 192.0.2.1\n---\n_id: \"2\"\n_source:\n  action: ALLOW, COUNT\n  httpRequest:\n    clientIp: 192.0.2.2\n"},
+		{format: "xml", wantErr: true},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			var b bytes.Buffer
+			f, err := newFormatter(&b, tt.format, tt.columns)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("in: %v err: %v wantErr: %v", tt.format, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			for _, doc := range docs {
+				if err := f.Write([]byte(doc)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := b.String(); got != tt.want {
+				t.Fatalf("in: %v got: %q want: %q", tt.format, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestColumnsOf(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{in: `{"a":1,"b":{"c":"x","d":[1,2]}}`, want: []string{"a", "b.c", "b.d"}},
+		{in: `{"rule.ruleset":"wafv2-linux"}`, want: []string{`rule\.ruleset`}},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			got := columnsOf([]byte(tt.in))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("in: %v got: %v want: %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
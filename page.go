@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// Page wraps the metadata of a search response page.
+type Page struct {
+	ScrollID string
+	PitID    string
+	Took     int64
+	Total    int64
+	Hits     int64
+}
+
+// Decode reads a search response from r and calls fn for every hit as soon
+// as it is decoded, so that a page is never held in memory as a whole.
+// The fields of p are set in the order they appear in the response.
+func (p *Page) Decode(r io.Reader, fn func(hit []byte) error) error {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "_scroll_id":
+			err = dec.Decode(&p.ScrollID)
+		case "pit_id":
+			err = dec.Decode(&p.PitID)
+		case "took":
+			err = dec.Decode(&p.Took)
+		case "hits":
+			err = p.decodeHits(dec, fn)
+		default:
+			err = skipValue(dec)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+func (p *Page) decodeHits(dec *json.Decoder, fn func(hit []byte) error) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "total":
+			var total json.RawMessage
+			if err = dec.Decode(&total); err != nil {
+				return err
+			}
+			// hits.total is a number when rest_total_hits_as_int is set.
+			var v struct {
+				Value int64 `json:"value"`
+			}
+			if err = json.Unmarshal(total, &v); err != nil {
+				err = json.Unmarshal(total, &v.Value)
+			}
+			p.Total = v.Value
+		case "hits":
+			if err = expectDelim(dec, '['); err != nil {
+				return err
+			}
+			for dec.More() {
+				var hit json.RawMessage
+				if err = dec.Decode(&hit); err != nil {
+					return err
+				}
+				p.Hits++
+				if err = fn(hit); err != nil {
+					return err
+				}
+			}
+			err = expectDelim(dec, ']')
+		default:
+			err = skipValue(dec)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+// readPage decodes the search response res into p and closes its body.
+func readPage(res *esapi.Response, p *Page, fn func(hit []byte) error) error {
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return responseError(res)
+	}
+	var ferr error
+	err := p.Decode(res.Body, func(hit []byte) error {
+		ferr = fn(hit)
+		return ferr
+	})
+	if ferr != nil {
+		return ferr
+	}
+	if err != nil {
+		return fmt.Errorf("Error parsing the response body: %s", err)
+	}
+	return nil
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != delim {
+		return fmt.Errorf("expected %q but got %v", delim, t)
+	}
+	return nil
+}
+
+func skipValue(dec *json.Decoder) error {
+	var v json.RawMessage
+	return dec.Decode(&v)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPageDecode(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in       string
+		want     Page
+		wantHits []string
+		wantErr  bool
+	}{
+		{
+			in:       `{"_scroll_id":"sid","took":3,"timed_out":false,"_shards":{"total":1},"hits":{"total":{"value":2,"relation":"eq"},"max_score":null,"hits":[{"_id":"1"},{"_id":"2","_source":{"a":[1,2]}}]}}`,
+			want:     Page{ScrollID: "sid", Took: 3, Total: 2, Hits: 2},
+			wantHits: []string{`{"_id":"1"}`, `{"_id":"2","_source":{"a":[1,2]}}`},
+		},
+		{
+			in:   `{"took":1,"hits":{"total":5,"hits":[]}}`,
+			want: Page{Took: 1, Total: 5},
+		},
+		{in: `[]`, wantErr: true},
+		{in: `{"hits":{"hits":[{"_id":`, wantErr: true},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			var (
+				got  Page
+				hits []string
+			)
+			err := got.Decode(strings.NewReader(tt.in), func(hit []byte) error {
+				hits = append(hits, string(hit))
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("in: %v err: %v wantErr: %v", tt.in, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got != tt.want {
+				t.Fatalf("in: %v got: %v want: %v", tt.in, got, tt.want)
+			}
+			if !reflect.DeepEqual(hits, tt.wantHits) {
+				t.Fatalf("in: %v got: %v want: %v", tt.in, hits, tt.wantHits)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/rs/zerolog/log"
+	"github.com/tidwall/gjson"
+	"github.com/urfave/cli/v2"
+)
+
+// Paginator fetches the pages of a search one after another.
+type Paginator interface {
+	// Next fetches the next page into p, calling fn for every hit.
+	Next(ctx context.Context, p *Page, fn func(hit []byte) error) error
+	// Close releases the search context held on the cluster.
+	Close(ctx context.Context) error
+}
+
+// newPaginator returns the Paginator selected by the --paginate flag for the
+// search of body on index. opts are applied to every search request.
+func newPaginator(c *cli.Context, es *elasticsearch.Client, index []string, body []byte, opts []func(*esapi.SearchRequest)) (Paginator, error) {
+	keepAlive := c.Duration("keep-alive")
+	switch c.String("paginate") {
+	case "scroll":
+		return &scrollPaginator{es: es, index: index, body: body, opts: opts, keepAlive: keepAlive}, nil
+	case "pit":
+		sort := []interface{}{map[string]string{c.String("tiebreaker"): "asc"}}
+		return &pitPaginator{es: es, index: index, body: body, opts: opts, keepAlive: keepAlive, sort: sort}, nil
+	default:
+		return nil, fmt.Errorf("unknown pagination %q", c.String("paginate"))
+	}
+}
+
+// scrollPaginator pages with the scroll API.
+type scrollPaginator struct {
+	es        *elasticsearch.Client
+	index     []string
+	body      []byte
+	opts      []func(*esapi.SearchRequest)
+	keepAlive time.Duration
+	scrollID  string
+}
+
+func (s *scrollPaginator) Next(ctx context.Context, p *Page, fn func(hit []byte) error) error {
+	var (
+		res *esapi.Response
+		err error
+	)
+	if s.scrollID == "" {
+		opts := append([]func(*esapi.SearchRequest){
+			s.es.Search.WithContext(ctx),
+			s.es.Search.WithIndex(s.index...),
+			s.es.Search.WithBody(bytes.NewReader(s.body)),
+			s.es.Search.WithScroll(s.keepAlive),
+			s.es.Search.WithSort("_doc:asc"),
+			s.es.Search.WithTrackTotalHits(true),
+		}, s.opts...)
+		res, err = s.es.Search(opts...)
+	} else {
+		res, err = s.es.Scroll(
+			s.es.Scroll.WithContext(ctx),
+			s.es.Scroll.WithScrollID(s.scrollID),
+			s.es.Scroll.WithScroll(s.keepAlive),
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("Error getting response: %s", err)
+	}
+	if err := readPage(res, p, fn); err != nil {
+		return err
+	}
+	// in any case, only the most recently received _scroll_id should be used.
+	// See: https://www.elastic.co/guide/en/elasticsearch/reference/master/paginate-search-results.html#scroll-search-results
+	s.scrollID = p.ScrollID
+	log.Debug().Msgf("sid: %v", s.scrollID)
+	return nil
+}
+
+// Close clears the scroll context.
+func (s *scrollPaginator) Close(ctx context.Context) error {
+	if s.scrollID == "" {
+		return nil
+	}
+	body, err := json.Marshal(map[string][]string{"scroll_id": {s.scrollID}})
+	if err != nil {
+		return err
+	}
+	res, err := s.es.ClearScroll(
+		s.es.ClearScroll.WithContext(ctx),
+		s.es.ClearScroll.WithBody(bytes.NewReader(body)),
+	)
+	if err != nil {
+		return fmt.Errorf("Error getting response: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return responseError(res)
+	}
+	log.Debug().Msgf("cleared scroll: %v", s.scrollID)
+	s.scrollID = ""
+	return nil
+}
+
+// pitPaginator pages with a point in time and search_after.
+type pitPaginator struct {
+	es        *elasticsearch.Client
+	index     []string
+	body      []byte
+	opts      []func(*esapi.SearchRequest)
+	keepAlive time.Duration
+	sort      []interface{}
+	pitID     string
+	after     json.RawMessage
+	tracked   bool
+}
+
+func (s *pitPaginator) Next(ctx context.Context, p *Page, fn func(hit []byte) error) error {
+	if s.pitID == "" {
+		if err := s.open(ctx); err != nil {
+			return err
+		}
+	}
+
+	values := map[string]interface{}{
+		"pit": map[string]string{
+			"id":         s.pitID,
+			"keep_alive": formatKeepAlive(s.keepAlive),
+		},
+		"sort": s.sort,
+	}
+	if s.after != nil {
+		values["search_after"] = s.after
+	}
+	body, err := mergeBody(s.body, values)
+	if err != nil {
+		return err
+	}
+	opts := append([]func(*esapi.SearchRequest){
+		s.es.Search.WithContext(ctx),
+		s.es.Search.WithBody(bytes.NewReader(body)),
+		// Count the total hits once, for the first page only.
+		s.es.Search.WithTrackTotalHits(!s.tracked),
+	}, s.opts...)
+	res, err := s.es.Search(opts...)
+	if err != nil {
+		return fmt.Errorf("Error getting response: %s", err)
+	}
+	err = readPage(res, p, func(hit []byte) error {
+		s.after = json.RawMessage(gjson.GetBytes(hit, "sort").Raw)
+		return fn(hit)
+	})
+	if err != nil {
+		return err
+	}
+	s.tracked = true
+	// The point in time id can change between searches, always use the latest.
+	if p.PitID != "" {
+		s.pitID = p.PitID
+	}
+	log.Debug().Msgf("pit: %v", s.pitID)
+	return nil
+}
+
+func (s *pitPaginator) open(ctx context.Context) error {
+	res, err := s.es.OpenPointInTime(
+		s.es.OpenPointInTime.WithContext(ctx),
+		s.es.OpenPointInTime.WithIndex(s.index...),
+		s.es.OpenPointInTime.WithKeepAlive(formatKeepAlive(s.keepAlive)),
+	)
+	if err != nil {
+		return fmt.Errorf("Error getting response: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return responseError(res)
+	}
+	var pit struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&pit); err != nil {
+		return fmt.Errorf("Error parsing the response body: %s", err)
+	}
+	s.pitID = pit.ID
+	log.Debug().Msgf("opened pit: %v", s.pitID)
+	return nil
+}
+
+// Close closes the point in time.
+func (s *pitPaginator) Close(ctx context.Context) error {
+	if s.pitID == "" {
+		return nil
+	}
+	body, err := json.Marshal(map[string]string{"id": s.pitID})
+	if err != nil {
+		return err
+	}
+	res, err := s.es.ClosePointInTime(
+		s.es.ClosePointInTime.WithContext(ctx),
+		s.es.ClosePointInTime.WithBody(bytes.NewReader(body)),
+	)
+	if err != nil {
+		return fmt.Errorf("Error getting response: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return responseError(res)
+	}
+	log.Debug().Msgf("closed pit: %v", s.pitID)
+	s.pitID = ""
+	return nil
+}
+
+// formatKeepAlive formats d in the time units of Elasticsearch.
+func formatKeepAlive(d time.Duration) string {
+	return fmt.Sprintf("%dms", d.Milliseconds())
+}
+
+// closePaginator closes p, even when the search context has been canceled.
+func closePaginator(p Paginator) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := p.Close(ctx); err != nil {
+		log.Warn().Err(err).Msg("Error releasing the search context")
+	}
+}
+
+// withSignals returns a copy of parent that is canceled on SIGINT or SIGTERM.
+func withSignals(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(ch)
+		select {
+		case s := <-ch:
+			log.Warn().Msgf("received %s, stopping", s)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
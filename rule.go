@@ -0,0 +1,261 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// builtinRules holds the rule definitions shipped with escli.
+//
+//go:embed rules/*.json
+var builtinRules embed.FS
+
+// Rule is a named query definition selected by search --rule.
+type Rule struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Query       json.RawMessage `json:"query"`
+	// Source is the file the rule was loaded from.
+	Source string `json:"source"`
+}
+
+// Rules is a registry of rules by name.
+type Rules map[string]*Rule
+
+// defaultRulesDir returns the directory of the user rules,
+// e.g. ~/.config/escli/rules.
+func defaultRulesDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "escli", "rules")
+}
+
+// loadRules returns the built-in rules and the rules of the JSON files in dir.
+// A rule in dir replaces the built-in rule of the same name.
+func loadRules(dir string) (Rules, error) {
+	rules := make(Rules)
+	entries, err := builtinRules.ReadDir("rules")
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		name := path.Join("rules", e.Name())
+		b, err := builtinRules.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := rules.add(b, "builtin:"+e.Name()); err != nil {
+			return nil, err
+		}
+	}
+	if dir == "" {
+		return rules, nil
+	}
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return rules, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".json" {
+			continue
+		}
+		name := filepath.Join(dir, f.Name())
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := rules.add(b, name); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+func (r Rules) add(b []byte, source string) error {
+	var rule Rule
+	if err := json.Unmarshal(b, &rule); err != nil {
+		return fmt.Errorf("Error parsing the rule %s: %s", source, err)
+	}
+	switch {
+	case rule.Name == "":
+		return fmt.Errorf("rule %s has no name", source)
+	case len(rule.Query) == 0:
+		return fmt.Errorf("rule %s has no query", source)
+	}
+	rule.Source = source
+	r[rule.Name] = &rule
+	return nil
+}
+
+// Get returns the rule called name.
+func (r Rules) Get(name string) (*Rule, error) {
+	rule, ok := r[name]
+	if !ok {
+		if s := suggest(name, r.Names()); s != "" {
+			return nil, fmt.Errorf("unknown rule %q; did you mean %q?", name, s)
+		}
+		return nil, fmt.Errorf("unknown rule %q; run `escli rules list` for the available rules", name)
+	}
+	return rule, nil
+}
+
+// suggest returns the candidate closest to name by case-insensitive edit
+// distance, or "" when none is close enough to be a likely typo.
+func suggest(name string, candidates []string) string {
+	best, dist := "", len(name)/3+2
+	for _, c := range candidates {
+		if d := editDistance(strings.ToLower(name), strings.ToLower(c)); d < dist {
+			best, dist = c, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev := make([]int, len(t)+1)
+	curr := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		curr[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(t)]
+}
+
+func minInt(v int, vs ...int) int {
+	for _, x := range vs {
+		if x < v {
+			v = x
+		}
+	}
+	return v
+}
+
+// Names returns the names of the rules in alphabetical order.
+func (r Rules) Names() []string {
+	names := make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var rulesCommand = &cli.Command{
+	Name:  "rules",
+	Usage: "List and show the rule groups of search --rule",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "list",
+			Usage:  "List the rule groups",
+			Action: rulesListAction,
+			Flags: []cli.Flag{
+				outputFlag("table"),
+				columnsFlag,
+			},
+		},
+		{
+			Name:      "show",
+			Usage:     "Show the definition of a rule group",
+			ArgsUsage: "NAME",
+			Action:    rulesShowAction,
+			BashComplete: func(c *cli.Context) {
+				if c.NArg() == 0 {
+					printRuleNames(c)
+				}
+			},
+			Flags: []cli.Flag{
+				outputFlag("json"),
+			},
+		},
+	},
+}
+
+func rulesListAction(c *cli.Context) error {
+	rules, err := loadRules(c.String("rules-dir"))
+	if err != nil {
+		return err
+	}
+	columns := c.StringSlice("columns")
+	if len(columns) == 0 {
+		columns = []string{"name", "description", "source"}
+	}
+	f, err := newFormatter(c.App.Writer, c.String("output"), columns)
+	if err != nil {
+		return err
+	}
+	for _, name := range rules.Names() {
+		doc, err := json.Marshal(rules[name])
+		if err != nil {
+			return err
+		}
+		if err := f.Write(doc); err != nil {
+			return err
+		}
+	}
+	return f.Close()
+}
+
+func rulesShowAction(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("usage: escli rules show NAME")
+	}
+	rules, err := loadRules(c.String("rules-dir"))
+	if err != nil {
+		return err
+	}
+	rule, err := rules.Get(strings.TrimSpace(c.Args().First()))
+	if err != nil {
+		return err
+	}
+	return writeDocument(c.App.Writer, c.String("output"), nil, rule)
+}
+
+// completeSearch completes the rule names after --rule and the flags otherwise.
+func completeSearch(c *cli.Context) {
+	if n := len(os.Args); n > 2 {
+		switch os.Args[n-2] {
+		case "--rule", "-rule", "-r":
+			printRuleNames(c)
+			return
+		}
+	}
+	cli.DefaultCompleteWithFlags(c.Command)(c)
+}
+
+func printRuleNames(c *cli.Context) {
+	// The flags of a command are not parsed when completing its flag values,
+	// so read the global --rules-dir from the parent context.
+	rules, err := loadRules(c.Lineage()[1].String("rules-dir"))
+	if err != nil {
+		return
+	}
+	for _, name := range rules.Names() {
+		fmt.Fprintln(c.App.Writer, name)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadRules(t *testing.T) {
+	t.Parallel()
+	rules, err := loadRules("./testdata/rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"AmazonIpReputation", "AnonymousIP", "Blocked", "BotControl", "KnownBadInputs", "SQLi"}
+	if got := rules.Names(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got: %v want: %v", got, want)
+	}
+	if got := rules["Blocked"].Source; got != filepath.Join("testdata", "rules", "blocked.json") {
+		t.Fatalf("got: %v want: %v", got, "testdata/rules/blocked.json")
+	}
+	if _, err := rules.Get("AnonymousIp"); err == nil {
+		t.Fatalf("in: %v err: %v wantErr: true", "AnonymousIp", err)
+	}
+}
+
+func TestLoadRulesInvalid(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in      string
+		wantErr bool
+	}{
+		{in: `{"name":"AnonymousIP","query":{"match_all":{}}}`, wantErr: false},
+		{in: `{"name":"NoQuery"}`, wantErr: true},
+		{in: `{"query":{"match_all":{}}}`, wantErr: true},
+		{in: `{`, wantErr: true},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			dir, err := ioutil.TempDir("", "escli")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			if err := ioutil.WriteFile(filepath.Join(dir, "rule.json"), []byte(tt.in), 0644); err != nil {
+				t.Fatal(err)
+			}
+			rules, err := loadRules(dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("in: %v err: %v wantErr: %v", tt.in, err, tt.wantErr)
+			}
+			if err == nil && rules["AnonymousIP"].Source == "builtin:anonymous-ip.json" {
+				t.Fatalf("in: %v got: %v want: the user rule", tt.in, rules["AnonymousIP"].Source)
+			}
+		})
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	t.Parallel()
+	candidates := []string{"AmazonIpReputation", "AnonymousIP", "BotControl", "KnownBadInputs", "SQLi"}
+	tests := []struct {
+		in, want string
+	}{
+		{in: "AnonymousIp", want: "AnonymousIP"},
+		{in: "AnonymusIP", want: "AnonymousIP"},
+		{in: "sqli", want: "SQLi"},
+		{in: "Botcontrol", want: "BotControl"},
+		{in: "AmazonIPReputaion", want: "AmazonIpReputation"},
+		{in: "Blocked", want: ""},
+		{in: "x", want: ""},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			got := suggest(tt.in, candidates)
+			if got != tt.want {
+				t.Fatalf("in: %v got: %v want: %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "", b: "", want: 0},
+		{a: "abc", b: "", want: 3},
+		{a: "kitten", b: "sitting", want: 3},
+		{a: "flaw", b: "lawn", want: 2},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			if got := editDistance(tt.a, tt.b); got != tt.want {
+				t.Fatalf("in: %v %v got: %v want: %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,18 +1,21 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
-	b64 "encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cheggaaa/pb/v3"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
 	"github.com/rs/zerolog/log"
 	"github.com/tidwall/gjson"
 	"github.com/urfave/cli/v2"
@@ -31,213 +34,475 @@ type AmplitudeID struct {
 }
 
 var searchCommand = &cli.Command{
-	Name:   "search",
-	Usage:  "Search elasticsearch",
-	Action: searchAction,
-	Flags: []cli.Flag{
+	Name:         "search",
+	Usage:        "Search elasticsearch",
+	Action:       searchAction,
+	BashComplete: completeSearch,
+	Flags: append([]cli.Flag{
+		indexFlag,
+		outputFlag("json"),
+		columnsFlag,
 		&cli.StringFlag{
 			Name:    "all",
 			Value:   "",
 			Aliases: []string{"a"},
 			Usage:   "Show match all",
 		},
+	}, append(queryFlags(),
+		&cli.BoolFlag{
+			Name:  "count",
+			Usage: "Count the matching documents instead of fetching them",
+		},
+		countByIndexFlag,
+		&cli.BoolFlag{
+			Name:     "print",
+			Required: false,
+			Value:    false,
+			Aliases:  []string{"P"},
+			Usage:    "Print Amplitude ID Summary. Requires --extract amplitude.",
+		},
+		&cli.IntFlag{
+			Name:  "size",
+			Value: 10000,
+			Usage: "Number of hits fetched per page",
+		},
 		&cli.StringFlag{
-			Name:    "filename",
-			Value:   "",
-			Aliases: []string{"f"},
-			Usage:   "Specify query json file",
+			Name:  "paginate",
+			Value: "scroll",
+			Usage: "Pagination method: scroll, or pit for point in time with search_after",
+		},
+		&cli.DurationFlag{
+			Name:  "keep-alive",
+			Value: 5 * time.Minute,
+			Usage: "How long the cluster keeps the scroll or point in time context between pages",
 		},
 		&cli.StringFlag{
-			Name:    "rule",
-			Value:   "",
-			Aliases: []string{"r"},
-			Usage:   "Specify rule group",
+			Name:  "tiebreaker",
+			Value: "_shard_doc",
+			Usage: "Field sorting the hits of a point in time search uniquely",
 		},
-		&cli.TimestampFlag{
-			Name:     "since",
-			Required: false,
-			Layout:   "2006-01-02 15:04:05",
-			Value:    cli.NewTimestamp(time.Now().Add(-30 * time.Minute)),
-			Aliases:  []string{"S"},
-			Usage:    "Start showing entries on or newer than the specified date respectively.",
+		&cli.IntFlag{
+			Name:  "slices",
+			Value: 1,
+			Usage: "Number of sliced scrolls fetched concurrently",
 		},
-		&cli.TimestampFlag{
-			Name:     "until",
-			Required: false,
-			Layout:   "2006-01-02 15:04:05",
-			Value:    cli.NewTimestamp(time.Now()),
-			Aliases:  []string{"U"},
-			Usage:    "Start showing entries on or older than the specified date, respectively.",
+		&cli.BoolFlag{
+			Name:  "ordered",
+			Usage: "Write the hits of sliced scrolls in slice order instead of as they arrive",
 		},
 		&cli.BoolFlag{
-			Name:     "print",
-			Required: false,
-			Value:    false,
-			Aliases:  []string{"P"},
-			Usage:    "Print Amplitude ID Summary.",
+			Name:    "follow",
+			Aliases: []string{"F"},
+			Usage:   "Keep polling for new documents from --since on and write them as they arrive, like tail -f, until interrupted. Writes ndjson unless --output is given",
+		},
+		&cli.DurationFlag{
+			Name:  "interval",
+			Value: 5 * time.Second,
+			Usage: "Polling interval of --follow",
+		},
+		&cli.DurationFlag{
+			Name:  "overlap",
+			Value: 30 * time.Second,
+			Usage: "How far back --follow searches again before the latest document for documents indexed late",
+		},
+		&cli.StringFlag{
+			Name:    "output-file",
+			Aliases: []string{"O"},
+			Usage:   "Write the results to a file instead of the standard output",
 		},
-	},
+		&cli.StringFlag{
+			Name:  "checkpoint",
+			Usage: "Record the progress to a file after every page and resume from it when it exists, over the time range recorded. Requires --paginate pit and --output-file",
+		},
+		&cli.StringSliceFlag{
+			Name:  "source",
+			Usage: "Return only the listed _source fields",
+		},
+		&cli.StringSliceFlag{
+			Name:  "fields",
+			Usage: "Retrieve the listed fields with the search fields option",
+		},
+		&cli.StringFlag{
+			Name:    "extract",
+			Aliases: []string{"x"},
+			Usage:   "Output the decoded headers or cookies of the hits instead of the raw hits. Accepts header:NAME[:DECODER] and cookie:NAME[:DECODER], where NAME is a glob pattern and DECODER is raw, base64, base64url, jwt, urljson or ga, or the presets amplitude and ga",
+		},
+		&cli.StringFlag{
+			Name:  "on-error",
+			Value: "fail",
+			Usage: "What to do with a hit that --extract cannot decode: fail to abort, skip to count and drop it, or collect to also report the errors at the end",
+		},
+		&cli.StringFlag{
+			Name:  "quarantine",
+			Usage: "Record the hits dropped by --on-error skip or collect to an ndjson file with their _index, _id, raw value and error. Appended to when resuming a --checkpoint",
+		},
+	)...),
 }
 
 func searchAction(c *cli.Context) error {
 	w := c.App.Writer
+	process, err := newHitProcessor(c.String("extract"))
+	if err != nil {
+		return err
+	}
+	if c.Bool("print") && c.String("extract") != "amplitude" {
+		return fmt.Errorf("--print requires --extract amplitude")
+	}
+	if c.Bool("by-index") && !c.Bool("count") {
+		return fmt.Errorf("--by-index requires --count")
+	}
+	policy, err := newErrorPolicy(c.String("on-error"), c.String("quarantine"))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := policy.Close(); err != nil {
+			log.Warn().Err(err).Msg("Error closing the quarantine file")
+		}
+	}()
+	defer policy.Report()
+	start, end, err := timeRange(c)
+	if err != nil {
+		return err
+	}
+	if path := c.String("checkpoint"); path != "" {
+		// Relative times such as the default now-30m resolve differently
+		// every run, so a resumed export searches its recorded range.
+		if start, end, err = checkpointRange(path, start, end); err != nil {
+			return err
+		}
+	}
+	query, err := rangeQuery(c, start, end)
+	if err != nil {
+		return err
+	}
+	if fields := c.StringSlice("fields"); len(fields) > 0 {
+		if query, err = withFields(query, fields); err != nil {
+			return err
+		}
+	}
+	body, err := ioutil.ReadAll(query)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msgf("query: %s", body)
+	if c.Bool("render-only") {
+		fmt.Fprintf(w, "%s\n", body)
+		return nil
+	}
+	if c.Bool("count") {
+		return countQuery(c, body)
+	}
+
 	es, err := newClient(c)
 	if err != nil {
 		return err
 	}
 
-	m, _ := time.ParseDuration("5m")
-	query, err := buildQuery(c)
-	log.Debug().Msgf("query: %s", query)
+	ctx, cancel := withSignals(context.Background())
+	defer cancel()
+
+	idx, err := indices(c)
 	if err != nil {
 		return err
 	}
+	if err := resolveIndices(ctx, es, idx); err != nil {
+		return err
+	}
 
-	res, err := es.Search(
-		es.Search.WithContext(context.Background()),
-		es.Search.WithIndex("log-aws-waf-*"),
-		es.Search.WithBody(query),
-		es.Search.WithPretty(),
-		es.Search.WithSize(10000),
-		es.Search.WithScroll(m),
-		es.Search.WithSource("httpRequest.headers"),
-		es.Search.WithSort("_doc:asc"),
+	opts := []func(*esapi.SearchRequest){
+		es.Search.WithSize(c.Int("size")),
+	}
+	source := c.StringSlice("source")
+	if len(source) == 0 && c.String("extract") != "" {
+		source = []string{"httpRequest.headers"}
+	}
+	if len(source) > 0 {
+		opts = append(opts, es.Search.WithSource(source...))
+	}
+	if c.Bool("follow") {
+		if err := policy.Open(false); err != nil {
+			return err
+		}
+		return follow(ctx, c, es, idx, opts, process, policy)
+	}
+	n := c.Int("slices")
+	if n < 1 {
+		n = 1
+	}
+	if n > 1 && c.String("paginate") != "scroll" {
+		return fmt.Errorf("--slices requires --paginate scroll")
+	}
+
+	var (
+		f    Formatter
+		k    *checkpointer
+		file *os.File
+		buf  *bufio.Writer
 	)
+	format, columns := c.String("output"), c.StringSlice("columns")
+	switch name := c.String("output-file"); {
+	case c.String("checkpoint") != "":
+		switch {
+		case name == "":
+			return fmt.Errorf("--checkpoint requires --output-file")
+		case c.String("paginate") != "pit":
+			return fmt.Errorf("--checkpoint requires --paginate pit")
+		case c.String("tiebreaker") == "_shard_doc":
+			return fmt.Errorf("--checkpoint requires --tiebreaker to name a unique sortable field")
+		}
+		search := searchFingerprint(strings.Join(idx, ","), string(body), c.String("tiebreaker"), c.String("extract"), format, strings.Join(columns, ","))
+		if k, err = newCheckpointer(c.String("checkpoint"), name, format, columns, search, start, end); err != nil {
+			return err
+		}
+		// The output file is flushed and closed once the search completes,
+		// this only cleans up after a failure.
+		defer func() {
+			if err := k.Close(false); err != nil {
+				log.Warn().Err(err).Msg("Error closing the output file")
+			}
+		}()
+		f = k.f
+	case name != "":
+		if file, err = os.Create(name); err != nil {
+			return err
+		}
+		// The file is flushed and closed once the search completes, this
+		// only cleans up after a failure.
+		defer file.Close()
+		buf = bufio.NewWriter(file)
+		if f, err = newFormatter(buf, format, columns); err != nil {
+			return err
+		}
+	default:
+		if f, err = newFormatter(w, format, columns); err != nil {
+			return err
+		}
+	}
+
+	if err := policy.Open(k != nil && k.Resuming()); err != nil {
+		return err
+	}
+
+	out, err := newSliceOutput(f, n, c.Bool("ordered"))
 	if err != nil {
-		return fmt.Errorf("Error getting response: %s", err)
+		return err
 	}
-	defer res.Body.Close()
+	defer out.Close()
 
-	if res.IsError() {
-		var e map[string]interface{}
-		if err := json.NewDecoder(res.Body).Decode(&e); err != nil {
-			return fmt.Errorf("Error parsing the response body: %s", err)
-		}
-		// Print the response status and error information.
-		return fmt.Errorf("[%s] %s: %s",
-			res.Status(),
-			e["error"].(map[string]interface{})["type"],
-			e["error"].(map[string]interface{})["reason"],
-		)
-	}
-
-	var b bytes.Buffer
-	b.ReadFrom(res.Body)
-	total := gjson.GetBytes(b.Bytes(), "hits.total.value").Int()
-	bar := pb.Start64(total)
-	log.Debug().Msgf("total hits: %v", total)
-	hits := int64(len(gjson.GetBytes(b.Bytes(), "hits.hits").Array()))
-	log.Debug().Msgf("hits: %v", hits)
-	took := gjson.GetBytes(b.Bytes(), "took").Int()
-	sid := gjson.GetBytes(b.Bytes(), "_scroll_id").String()
-	log.Debug().Msgf("sid: %v", sid)
-
-	amplitudeIDs := make([]AmplitudeID, 0, hits)
-
-	for _, hit := range gjson.GetBytes(b.Bytes(), "hits.hits").Array() {
-		bar.Increment()
-		headers := gjson.Get(hit.Map()["_source"].String(), "httpRequest.headers").Array()
-		for _, header := range headers {
-			if header.Map()["name"].Str == "cookie" {
-				cookie := header.Map()["value"].Str
-				amplitudeID, err := cookieToAmplitudeID(cookie)
+	var (
+		mu                sync.Mutex
+		total, took, docs int64
+		summary           = make(amplitudeSummary)
+		firstErr          error
+	)
+	bar := pb.Start64(0)
+	handle := func(slice int) func(hit []byte) error {
+		return func(hit []byte) error {
+			bar.Increment()
+			h := gjson.ParseBytes(hit)
+			vs, err := process(h)
+			if err != nil {
+				return policy.Handle(h, err)
+			}
+			for _, v := range vs {
+				doc, err := json.Marshal(v)
 				if err != nil {
 					return err
 				}
-				if amplitudeID != (AmplitudeID{}) {
-					amplitudeIDs = append(amplitudeIDs, amplitudeID)
+				if err := out.Write(slice, doc); err != nil {
+					return err
+				}
+				mu.Lock()
+				if id, ok := v.(AmplitudeID); ok {
+					summary.Add(id)
 				}
+				docs++
+				mu.Unlock()
 			}
+			return nil
 		}
 	}
 
-	if total > hits {
-		for hits > 0 {
-			res, err := es.Scroll(
-				es.Scroll.WithContext(context.Background()),
-				es.Scroll.WithScrollID(sid),
-				es.Scroll.WithScroll(m),
-			)
-			if err != nil {
-				return fmt.Errorf("Error getting response: %s", err)
+	// Cancel every slice as soon as one of them fails.
+	sctx, scancel := context.WithCancel(ctx)
+	defer scancel()
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		b := body
+		if n > 1 {
+			slice := map[string]int{"id": i, "max": n}
+			if b, err = mergeBody(body, map[string]interface{}{"slice": slice}); err != nil {
+				return err
 			}
-			defer res.Body.Close()
-
-			if res.IsError() {
-				var e map[string]interface{}
-				if err := json.NewDecoder(res.Body).Decode(&e); err != nil {
-					return fmt.Errorf("Error parsing the response body: %s", err)
+		}
+		pg, err := newPaginator(c, es, idx, b, opts)
+		if err != nil {
+			return err
+		}
+		if k != nil {
+			k.pg = pg.(*pitPaginator)
+			k.pg.after = k.cp.SearchAfter
+			docs = k.cp.Docs
+			bar.SetCurrent(k.cp.Hits)
+		}
+		wg.Add(1)
+		go func(i int, pg Paginator) {
+			defer wg.Done()
+			defer closePaginator(pg)
+			first := true
+			ms, err := paginate(sctx, pg, handle(i), func(p *Page) error {
+				mu.Lock()
+				defer mu.Unlock()
+				if first {
+					total += p.Total
+					bar.SetTotal(total)
+					first = false
 				}
-				// Print the response status and error information.
-				return fmt.Errorf("[%s] %s: %s",
-					res.Status(),
-					e["error"].(map[string]interface{})["type"],
-					e["error"].(map[string]interface{})["reason"],
-				)
-			}
-
-			var b bytes.Buffer
-			b.ReadFrom(res.Body)
-			for _, hit := range gjson.GetBytes(b.Bytes(), "hits.hits").Array() {
-				bar.Increment()
-				headers := gjson.Get(hit.Map()["_source"].String(), "httpRequest.headers").Array()
-				for _, header := range headers {
-					if header.Map()["name"].Str == "cookie" {
-						cookie := header.Map()["value"].Str
-						amplitudeID, err := cookieToAmplitudeID(cookie)
-						if err != nil {
-							return err
-						}
-						if amplitudeID != (AmplitudeID{}) {
-							amplitudeIDs = append(amplitudeIDs, amplitudeID)
-						}
-					}
+				if k != nil {
+					return k.Save(p, docs)
 				}
+				return nil
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			took += ms
+			if err != nil && firstErr == nil {
+				firstErr = err
+				scancel()
 			}
-			hits = int64(len(gjson.GetBytes(b.Bytes(), "hits.hits").Array()))
-			took += gjson.GetBytes(b.Bytes(), "took").Int()
-			log.Debug().Msgf("hits: %v", hits)
-			log.Debug().Msgf("amplitude Id: %v", len(amplitudeIDs))
-			// in any case, only the most recently received _scroll_id should be used.
-			// See: https://www.elastic.co/guide/en/elasticsearch/reference/master/paginate-search-results.html#scroll-search-results
-			sid = gjson.GetBytes(b.Bytes(), "_scroll_id").String()
-			log.Debug().Msgf("sid: %v", sid)
-		}
+		}(i, pg)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := out.Flush(); err != nil {
+		return err
 	}
 	bar.Finish()
-	if len(amplitudeIDs) > 0 {
-		out, err := json.Marshal(&amplitudeIDs)
-		if err != nil {
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if file != nil {
+		if err := buf.Flush(); err != nil {
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	if k != nil {
+		if err := k.Close(true); err != nil {
 			return err
 		}
-		fmt.Fprintf(w, "%v\n", string(out))
 	}
 
-	log.Debug().Msgf("amplitude Id count: %v", len(amplitudeIDs))
+	log.Debug().Msgf("docs count: %v", docs)
 	log.Debug().Msgf(
-		"[%s] %d hits; took: %dms",
-		res.Status(),
+		"%d hits; took: %dms",
 		total,
 		took,
 	)
 	if c.Bool("print") {
-		printAmplitudeIDSummary(c, amplitudeIDs)
+		summary.Print(w)
 	}
 	return nil
 }
 
-func printAmplitudeIDSummary(c *cli.Context, amplitudeIDs []AmplitudeID) {
-	w := c.App.Writer
-	memo := make(map[string]int)
-	for _, v := range amplitudeIDs {
-		memo[v.UserID]++
+// hitProcessor converts a search hit into the documents to output.
+type hitProcessor func(hit gjson.Result) ([]interface{}, error)
+
+// newHitProcessor returns the hitProcessor for the named extractor, a preset
+// or an extractor spec parsed by parseExtractor. An empty name outputs the
+// raw hits.
+func newHitProcessor(name string) (hitProcessor, error) {
+	switch name {
+	case "":
+		return rawHit, nil
+	case "amplitude":
+		return amplitudeHit, nil
+	default:
+		e, err := parseExtractor(name)
+		if err != nil {
+			return nil, err
+		}
+		return newExtractHit(e), nil
+	}
+}
+
+// rawHit outputs the hit as it is.
+func rawHit(hit gjson.Result) ([]interface{}, error) {
+	return []interface{}{json.RawMessage(hit.Raw)}, nil
+}
+
+// amplitudeExtractor extracts the amplitude_id cookies.
+var amplitudeExtractor, _ = parseExtractor("amplitude")
+
+// amplitudeHit outputs the AmplitudeID found in the cookie headers of the hit.
+func amplitudeHit(hit gjson.Result) ([]interface{}, error) {
+	values, err := amplitudeExtractor.Extract(hit)
+	if err != nil {
+		return nil, err
 	}
+	var docs []interface{}
+	for _, v := range values {
+		var amplitudeID AmplitudeID
+		if err := json.Unmarshal(v.Value, &amplitudeID); err != nil {
+			return nil, &ExtractError{Source: "cookie", Name: v.Name, Decoder: "amplitude", Value: v.Raw, Err: fmt.Errorf("Error to unmarshal JSON into AmplitudeID struct: %s", err)}
+		}
+		if amplitudeID != (AmplitudeID{}) {
+			docs = append(docs, amplitudeID)
+		}
+	}
+	return docs, nil
+}
+
+// withFields sets the fields option of the search request body.
+func withFields(query io.Reader, fields []string) (io.Reader, error) {
+	b, err := ioutil.ReadAll(query)
+	if err != nil {
+		return nil, err
+	}
+	b, err = mergeBody(b, map[string]interface{}{"fields": fields})
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(b), nil
+}
+
+// mergeBody sets values at the top level of the search request body b.
+func mergeBody(b []byte, values map[string]interface{}) ([]byte, error) {
+	body := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &body); err != nil {
+		return nil, fmt.Errorf("Error parsing the query: %s", err)
+	}
+	for k, v := range values {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		body[k] = raw
+	}
+	return json.Marshal(body)
+}
+
+// amplitudeSummary counts the AmplitudeID values per user as they are found.
+type amplitudeSummary map[string]int
+
+// Add counts amplitudeID.
+func (s amplitudeSummary) Add(amplitudeID AmplitudeID) {
+	s[amplitudeID.UserID]++
+}
+
+// Print writes the users in ascending order of their counts.
+func (s amplitudeSummary) Print(w io.Writer) {
 	type user struct {
 		uuid  string
 		count int
 	}
-	userIDs := make([]user, 0, len(memo))
-	for k, v := range memo {
+	userIDs := make([]user, 0, len(s))
+	for k, v := range s {
 		userIDs = append(userIDs, user{uuid: k, count: v})
 	}
 	sort.SliceStable(userIDs, func(i, j int) bool {
@@ -254,17 +519,13 @@ func printAmplitudeIDSummary(c *cli.Context, amplitudeIDs []AmplitudeID) {
 // cookieToAmplitudeID is AmplitudeID extractiong from cookie
 func cookieToAmplitudeID(cookie string) (AmplitudeID, error) {
 	var amplitudeID AmplitudeID
-	for _, values := range strings.Split(cookie, ";") {
-		if strings.Contains(values, "amplitude_id") {
-			sEnc := trimNextEqual(values)
-			sDec, err := b64.StdEncoding.DecodeString(sEnc)
-			if err != nil {
-				return (AmplitudeID{}), fmt.Errorf("Error encodeings the amplitude_id value: %s", err)
-			}
-			err = json.Unmarshal(sDec, &amplitudeID)
-			if err != nil {
-				return (AmplitudeID{}), fmt.Errorf("Error to unmarshal JSON into AmplitudeID struct: %s", err)
-			}
+	values, err := amplitudeExtractor.FromHeader("cookie", cookie)
+	if err != nil {
+		return (AmplitudeID{}), err
+	}
+	for _, v := range values {
+		if err := json.Unmarshal(v.Value, &amplitudeID); err != nil {
+			return (AmplitudeID{}), fmt.Errorf("Error to unmarshal JSON into AmplitudeID struct: %s", err)
 		}
 	}
 	return amplitudeID, nil
@@ -281,226 +542,203 @@ func trimNextEqual(s string) string {
 	return s[i+1:]
 }
 
+// queryFlags returns the flags read by buildQuery.
+func queryFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "filename",
+			Value:   "",
+			Aliases: []string{"f"},
+			Usage:   "Specify query json file. The file is a Go text/template, and the time range is added to its query unless --no-time-filter is given",
+		},
+		&cli.StringSliceFlag{
+			Name:  "var",
+			Usage: "Set a variable of the --filename template as key=value. May be repeated",
+		},
+		&cli.StringFlag{
+			Name:  "vars-file",
+			Usage: "Read the variables of the --filename template from a JSON or YAML file",
+		},
+		&cli.BoolFlag{
+			Name:  "render-only",
+			Usage: "Print the query instead of searching",
+		},
+		&cli.StringFlag{
+			Name:    "rule",
+			Value:   "",
+			Aliases: []string{"r"},
+			Usage:   "Specify rule group. See `escli rules list`",
+		},
+		&cli.StringFlag{
+			Name:    "query",
+			Aliases: []string{"q"},
+			Usage:   "Search with a query_string (Lucene syntax) query, e.g. 'httpRequest.clientIp:192.0.2.1 AND action:BLOCK'",
+		},
+		&cli.BoolFlag{
+			Name:  "kql",
+			Usage: "Read --query as Kibana Query Language instead of Lucene syntax",
+		},
+		&cli.StringFlag{
+			Name:     "since",
+			Required: false,
+			Value:    "now-30m",
+			Aliases:  []string{"S"},
+			Usage:    "Start showing entries on or newer than the specified date respectively. Accepts e.g. 1h, now-15m, yesterday, 2006-01-02, \"2006-01-02 15:04:05\", RFC 3339 or Unix epochs.",
+		},
+		&cli.StringFlag{
+			Name:     "until",
+			Required: false,
+			Value:    "now",
+			Aliases:  []string{"U"},
+			Usage:    "Start showing entries on or older than the specified date, respectively. Accepts the same expressions as --since.",
+		},
+		&cli.StringFlag{
+			Name:  "time-field",
+			Usage: "Field filtered by --since and --until (default: the context time-field or @timestamp)",
+		},
+		&cli.StringFlag{
+			Name:  "time-format",
+			Usage: "Date format of the time range, e.g. epoch_millis or epoch_second (default: the context time-format or strict_date_optional_time)",
+		},
+		&cli.BoolFlag{
+			Name:  "no-time-filter",
+			Usage: "Do not filter by --since and --until, also not the query of --filename",
+		},
+		&cli.StringFlag{
+			Name:    "timezone",
+			Value:   "Local",
+			Aliases: []string{"tz"},
+			Usage:   "Time zone of the --since and --until times without an offset, e.g. UTC or Asia/Tokyo",
+		},
+	}
+}
+
+// MatchAllQuery is the query searching without --filename, --rule or --query.
+const MatchAllQuery = `{"query":{"match_all":{}}}`
+
+// buildQuery returns the query of the flags over their time range.
 func buildQuery(c *cli.Context) (io.Reader, error) {
+	start, end, err := timeRange(c)
+	if err != nil {
+		return nil, err
+	}
+	return rangeQuery(c, start, end)
+}
+
+// rangeQuery returns the query of the flags over the time range from start
+// to end.
+func rangeQuery(c *cli.Context, start, end time.Time) (io.Reader, error) {
 	filename := c.String("filename")
 	log.Debug().Msgf("filename: %s", filename)
-	since := c.Timestamp("since").Format(time.RFC3339Nano)
-	log.Debug().Msgf("since: %s", since)
-	until := c.Timestamp("until").Format(time.RFC3339Nano)
-	log.Debug().Msgf("until: %s", until)
+	switch {
+	case filename != "" && c.String("query") != "":
+		return nil, fmt.Errorf("--query cannot be combined with --filename")
+	case c.Bool("kql") && c.String("query") == "":
+		return nil, fmt.Errorf("--kql requires --query")
+	}
+	log.Debug().Msgf("since: %s", start.Format(time.RFC3339Nano))
+	log.Debug().Msgf("until: %s", end.Format(time.RFC3339Nano))
+	tf, err := timeFilter(c, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return filteredQuery(c, tf)
+}
+
+// filteredQuery returns the query of the flags filtered by tf.
+func filteredQuery(c *cli.Context, tf *TimeFilter) (io.Reader, error) {
+	filename := c.String("filename")
+	clause, err := tf.Clause()
+	if err != nil {
+		return nil, err
+	}
 	if filename == "" {
-		var b strings.Builder
-		switch c.String("rule") {
-		case "AmazonIpReputation":
-			b.WriteString(fmt.Sprintf(AmazonIPReputationQuery, since, until))
-		case "AnonymousIP":
-			b.WriteString(fmt.Sprintf(AnonymousIPQuery, since, until))
+		var must []json.RawMessage
+		if name := c.String("rule"); name != "" {
+			rules, err := loadRules(c.String("rules-dir"))
+			if err != nil {
+				return nil, err
+			}
+			rule, err := rules.Get(name)
+			if err != nil {
+				return nil, err
+			}
+			must = append(must, rule.Query)
+		}
+		if q := c.String("query"); q != "" {
+			dsl, err := commandLineQuery(q, c.Bool("kql"))
+			if err != nil {
+				return nil, err
+			}
+			must = append(must, dsl)
+		}
+		query := []byte(MatchAllQuery)
+		switch len(must) {
+		case 0:
+		case 1:
+			query, err = json.Marshal(map[string]json.RawMessage{"query": must[0]})
 		default:
-			b.WriteString(fmt.Sprintf(MatchAllQuery, since, until))
+			query, err = json.Marshal(map[string]interface{}{
+				"query": map[string]interface{}{"bool": map[string]interface{}{"must": must}},
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+		if query, err = withTimeFilter(query, clause, tf.Disabled); err != nil {
+			return nil, err
 		}
-		return strings.NewReader(b.String()), nil
+		return bytes.NewReader(query), nil
 	}
 	query, err := ioutil.ReadFile(filename)
-	log.Debug().Msgf("query: %v", query)
 	if err != nil {
 		return nil, err
 	}
+	vars, err := queryVars(c)
+	if err != nil {
+		return nil, err
+	}
+	if query, err = renderQuery(filename, query, vars, tf); err != nil {
+		return nil, err
+	}
+	log.Debug().Msgf("query: %s", query)
+	if query, err = withTimeFilter(query, clause, tf.Disabled); err != nil {
+		return nil, fmt.Errorf("query file %s: %s", filename, err)
+	}
 	return bytes.NewReader(query), nil
 }
 
-// AmazonIPReputationQuery is a query string that match Amazon IP Reputation List Rule Group.
-// If the time is not specified, the number of matches becomes large, so it is specified separately.
-const AmazonIPReputationQuery = `{
-  "query": {
-    "bool": {
-      "must": [],
-      "filter": [
-        {
-          "bool": {
-            "filter": [
-              {
-                "bool": {
-                  "must_not": {
-                    "bool": {
-                      "should": [
-                        {
-                          "match": {
-                            "ruleGroupList.terminatingRule.ruleId": "HostingProviderIPList"
-                          }
-                        }
-                      ],
-                      "minimum_should_match": 1
-                    }
-                  }
-                }
-              },
-              {
-                "bool": {
-                  "filter": [
-                    {
-                      "bool": {
-                        "should": [
-                          {
-                            "match": {
-                              "ruleGroupList.terminatingRule.action": "BLOCK"
-                            }
-                          }
-                        ],
-                        "minimum_should_match": 1
-                      }
-                    },
-                    {
-                      "bool": {
-                        "should": [
-                          {
-                            "query_string": {
-                              "fields": [
-                                "ruleGroupList.terminatingRule.ruleId"
-                              ],
-                              "query": "AWSManagedIPReputationList_*"
-                            }
-                          }
-                        ],
-                        "minimum_should_match": 1
-                      }
-                    }
-                  ]
-                }
-              }
-            ]
-          }
-        },
-        {
-          "match_all": {}
-        },
-        {
-          "match_phrase": {
-            "rule.ruleset": "wafv2-linux"
-          }
-        },
-        {
-          "range": {
-            "@timestamp": {
-              "gte": %q,
-              "lte": %q,
-              "format": "strict_date_optional_time"
-            }
-          }
-        }
-      ]
-    }
-  }
-}`
-
-// AnonymousIPQuery is a query string that match Anonymous IP List Rule Group.
-// If the time is not specified, the number of matches becomes large, so it is specified separately.
-const AnonymousIPQuery = `{
-  "query": {
-    "bool": {
-      "must": [
-        {
-          "match_all": {}
-        }
-      ],
-      "filter": [
-        {
-          "bool": {
-            "filter": [
-              {
-                "bool": {
-                  "must_not": {
-                    "bool": {
-                      "should": [
-                        {
-                          "match": {
-                            "ruleGroupList.terminatingRule.ruleId": "HostingProviderIPList"
-                          }
-                        }
-                      ],
-                      "minimum_should_match": 1
-                    }
-                  }
-                }
-              },
-              {
-                "bool": {
-                  "filter": [
-                    {
-                      "bool": {
-                        "should": [
-                          {
-                            "match": {
-                              "ruleGroupList.terminatingRule.action": "BLOCK"
-                            }
-                          }
-                        ],
-                        "minimum_should_match": 1
-                      }
-                    },
-                    {
-                      "bool": {
-                        "filter": [
-                          {
-                            "bool": {
-                              "should": [
-                                {
-                                  "match": {
-                                    "ruleGroupList.terminatingRule.ruleId": "AnonymousIPList"
-                                  }
-                                }
-                              ],
-                              "minimum_should_match": 1
-                            }
-                          }
-                        ]
-                      }
-                    }
-                  ]
-                }
-              }
-            ]
-          }
-        },
-        {
-          "match_phrase": {
-            "rule.ruleset": "wafv2-linux"
-          }
-        },
-        {
-          "range": {
-            "@timestamp": {
-              "gte": %q,
-              "lte": %q,
-              "format": "strict_date_optional_time"
-            }
-          }
-        }
-      ]
-    }
-  }
-}`
-
-// MatchAllQuery is a query string that matches all.
-// If the time is not specified, the number of matches becomes large, so it is specified separately.
-const MatchAllQuery = `{
-  "query": {
-    "bool": {
-      "must": [
-        {
-          "match_all": {}
-        }
-      ],
-      "filter": [
-        {
-          "range": {
-            "@timestamp": {
-              "gte": %q,
-              "lte": %q,
-              "format": "strict_date_optional_time"
-            }
-          }
-        }
-      ]
-    }
-  }
-}`
+// commandLineQuery returns the query of --query, a query_string query or
+// the translation of the KQL expression q.
+func commandLineQuery(q string, kql bool) (json.RawMessage, error) {
+	if kql {
+		return parseKQL(q)
+	}
+	return json.Marshal(map[string]interface{}{
+		"query_string": map[string]interface{}{"query": q},
+	})
+}
+
+// withTimeFilter combines the top-level query of the search request body b
+// with the time range filter clause. b is returned as is when disabled.
+func withTimeFilter(b, clause []byte, disabled bool) ([]byte, error) {
+	body := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &body); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %s", err)
+	}
+	query, ok := body["query"]
+	if !ok {
+		return nil, fmt.Errorf("no top-level query")
+	}
+	if disabled {
+		return b, nil
+	}
+	return mergeBody(b, map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must":   []json.RawMessage{query},
+				"filter": []json.RawMessage{clause},
+			},
+		},
+	})
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -40,19 +41,44 @@ func TestBuildQuery(t *testing.T) {
 	b := bytes.NewReader(f)
 	since := "2020-12-23 13:04:05"
 	until := "2020-12-23 14:15:16"
+	const clause = `{"range":{"@timestamp":{"format":"strict_date_optional_time","gte":"2020-12-23T13:04:05Z","lte":"2020-12-23T14:15:16Z"}}}`
+	rules, err := loadRules("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered := func(query json.RawMessage, clause string) io.Reader {
+		var b bytes.Buffer
+		_ = json.Compact(&b, query)
+		return bytes.NewReader([]byte(`{"query":{"bool":{"filter":[` + clause + `],"must":[` + b.String() + `]}}}`))
+	}
 	type in struct {
-		filename, rule, since, until string
+		filename, rule, query, since, until, timeField, timeFormat string
+		kql, noTimeFilter                                          bool
 	}
 	tests := []struct {
 		in      in
 		want    io.Reader
 		wantErr bool
 	}{
-		{in: in{filename: "", since: since, until: until}, want: strings.NewReader(fmt.Sprintf(MatchAllQuery, "2020-12-23T13:04:05Z", "2020-12-23T14:15:16Z")), wantErr: false},
+		{in: in{filename: "", since: since, until: until}, want: filtered(json.RawMessage(`{"match_all":{}}`), clause), wantErr: false},
 		{in: in{filename: "err", since: since, until: until}, want: nil, wantErr: true},
-		{in: in{filename: filename, since: since, until: until}, want: b, wantErr: false},
-		{in: in{rule: "AmazonIpReputation", since: since, until: until}, want: strings.NewReader(fmt.Sprintf(AmazonIPReputationQuery, "2020-12-23T13:04:05Z", "2020-12-23T14:15:16Z")), wantErr: false},
-		{in: in{rule: "AnonymousIP", since: since, until: until}, want: strings.NewReader(fmt.Sprintf(AnonymousIPQuery, "2020-12-23T13:04:05Z", "2020-12-23T14:15:16Z")), wantErr: false},
+		{in: in{filename: filename, since: since, until: until}, want: bytes.NewReader([]byte(`{"query":{"bool":{"filter":[` + clause + `],"must":[{"match_all":{}}]}},"size":10}`)), wantErr: false},
+		{in: in{filename: filename, since: since, until: until, noTimeFilter: true}, want: b, wantErr: false},
+		{in: in{filename: "./testdata/invalid.json", since: since, until: until}, want: nil, wantErr: true},
+		{in: in{filename: "./testdata/noquery.json", since: since, until: until}, want: nil, wantErr: true},
+		{in: in{rule: "AmazonIpReputation", since: since, until: until}, want: filtered(rules["AmazonIpReputation"].Query, clause), wantErr: false},
+		{in: in{rule: "AnonymousIP", since: since, until: until}, want: filtered(rules["AnonymousIP"].Query, clause), wantErr: false},
+		{in: in{rule: "Blocked", since: since, until: until}, want: filtered(json.RawMessage(`{"match":{"action":"BLOCK"}}`), clause), wantErr: false},
+		{in: in{rule: "AnonymousIp", since: since, until: until}, want: nil, wantErr: true},
+		{in: in{since: since, until: until, timeField: "event.created", timeFormat: "epoch_millis"}, want: filtered(json.RawMessage(`{"match_all":{}}`), `{"range":{"event.created":{"format":"epoch_millis","gte":"1608728645000","lte":"1608732916000"}}}`), wantErr: false},
+		{in: in{since: since, until: until, noTimeFilter: true}, want: bytes.NewReader([]byte(MatchAllQuery)), wantErr: false},
+		{in: in{since: "invalid", until: until}, want: nil, wantErr: true},
+		{in: in{query: "action:BLOCK AND httpRequest.country:JP", since: since, until: until}, want: filtered(json.RawMessage(`{"query_string":{"query":"action:BLOCK AND httpRequest.country:JP"}}`), clause), wantErr: false},
+		{in: in{query: "action:BLOCK", kql: true, since: since, until: until}, want: filtered(json.RawMessage(`{"match":{"action":"BLOCK"}}`), clause), wantErr: false},
+		{in: in{rule: "Blocked", query: "httpRequest.country:JP", kql: true, since: since, until: until}, want: filtered(json.RawMessage(`{"bool":{"must":[{"match":{"action":"BLOCK"}},{"match":{"httpRequest.country":"JP"}}]}}`), clause), wantErr: false},
+		{in: in{query: "action:(BLOCK", kql: true, since: since, until: until}, want: nil, wantErr: true},
+		{in: in{kql: true, since: since, until: until}, want: nil, wantErr: true},
+		{in: in{filename: filename, query: "action:BLOCK", since: since, until: until}, want: nil, wantErr: true},
 	}
 	for i, tt := range tests {
 		i, tt := i, tt
@@ -61,14 +87,21 @@ func TestBuildQuery(t *testing.T) {
 			flags := []cli.Flag{
 				&cli.StringFlag{Name: "filename"},
 				&cli.StringFlag{Name: "rule"},
-				&cli.TimestampFlag{Name: "since", Layout: "2006-01-02 15:04:05"},
-				&cli.TimestampFlag{Name: "until", Layout: "2006-01-02 15:04:05"},
+				&cli.StringFlag{Name: "query"},
+				&cli.BoolFlag{Name: "kql"},
+				&cli.StringFlag{Name: "since"},
+				&cli.StringFlag{Name: "until"},
+				&cli.StringFlag{Name: "timezone", Value: "UTC"},
+				&cli.StringFlag{Name: "rules-dir", Value: "./testdata/rules"},
+				&cli.StringFlag{Name: "time-field"},
+				&cli.StringFlag{Name: "time-format"},
+				&cli.BoolFlag{Name: "no-time-filter"},
 			}
 			set := flag.NewFlagSet("test", 0)
 			for _, fl := range flags {
 				_ = fl.Apply(set)
 			}
-			set.Parse([]string{"--filename", tt.in.filename, "--rule", tt.in.rule, "--since", tt.in.since, "--until", tt.in.until})
+			set.Parse([]string{"--filename", tt.in.filename, "--rule", tt.in.rule, "--query", tt.in.query, fmt.Sprintf("--kql=%t", tt.in.kql), "--since", tt.in.since, "--until", tt.in.until, "--time-field", tt.in.timeField, "--time-format", tt.in.timeFormat, fmt.Sprintf("--no-time-filter=%t", tt.in.noTimeFilter)})
 			c := cli.NewContext(nil, set, nil)
 			got, err := buildQuery(c)
 			if (err != nil) != tt.wantErr {
@@ -108,3 +141,34 @@ func TestCookieToAmplitudeID(t *testing.T) {
 		})
 	}
 }
+
+func TestWithFields(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in      string
+		fields  []string
+		want    string
+		wantErr bool
+	}{
+		{in: `{"query":{"match_all":{}}}`, fields: []string{"@timestamp"}, want: `{"fields":["@timestamp"],"query":{"match_all":{}}}`, wantErr: false},
+		{in: `{"query":{"match_all":{}},"fields":["a"]}`, fields: []string{"b", "c"}, want: `{"fields":["b","c"],"query":{"match_all":{}}}`, wantErr: false},
+		{in: `{`, fields: []string{"a"}, want: "", wantErr: true},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			r, err := withFields(strings.NewReader(tt.in), tt.fields)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("in: %v err: %v wantErr: %v", tt.in, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got, _ := ioutil.ReadAll(r)
+			if string(got) != tt.want {
+				t.Fatalf("in: %v got: %s want: %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
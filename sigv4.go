@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// DefaultAWSService is the signing name of Amazon OpenSearch Service and
+// Amazon Elasticsearch Service domains.
+const DefaultAWSService = "es"
+
+// AWSConfig is the AWS request signing configuration of a context.
+type AWSConfig struct {
+	SigV4   bool   `yaml:"sigv4,omitempty"`
+	Region  string `yaml:"region,omitempty"`
+	Service string `yaml:"service,omitempty"`
+	// Profile is the profile of the shared credentials and config files.
+	Profile string `yaml:"profile,omitempty"`
+}
+
+// AWSCredentials are the credentials signing the requests.
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// awsOptions returns the AWS configuration of the context with the settings
+// of the flags.
+func awsOptions(c *cli.Context, ctx *Context) AWSConfig {
+	cfg := ctx.AWS
+	for name, v := range map[string]*string{
+		"aws-region":  &cfg.Region,
+		"aws-service": &cfg.Service,
+		"aws-profile": &cfg.Profile,
+	} {
+		if c.IsSet(name) {
+			*v = c.String(name)
+		}
+	}
+	if c.IsSet("aws-sigv4") {
+		cfg.SigV4 = c.Bool("aws-sigv4")
+	}
+	return cfg
+}
+
+// loadAWSCredentials returns the credentials and the region of cfg, looked up
+// like the AWS CLI does: the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+// environment variables unless a profile is configured, then the profile,
+// AWS_PROFILE or default, of the shared credentials and config files.
+func loadAWSCredentials(cfg AWSConfig, getenv func(string) string) (AWSCredentials, string, error) {
+	profile := firstNonEmpty(cfg.Profile, getenv("AWS_PROFILE"), "default")
+	home, _ := os.UserHomeDir()
+	credentialsFile := firstNonEmpty(getenv("AWS_SHARED_CREDENTIALS_FILE"), filepath.Join(home, ".aws", "credentials"))
+	configFile := firstNonEmpty(getenv("AWS_CONFIG_FILE"), filepath.Join(home, ".aws", "config"))
+
+	credentialsSections, err := readINI(credentialsFile)
+	if err != nil {
+		return AWSCredentials{}, "", err
+	}
+	configSections, err := readINI(configFile)
+	if err != nil {
+		return AWSCredentials{}, "", err
+	}
+	section := "profile " + profile
+	if profile == "default" {
+		section = profile
+	}
+	config := configSections[section]
+
+	region := firstNonEmpty(cfg.Region, getenv("AWS_REGION"), getenv("AWS_DEFAULT_REGION"), config["region"])
+	if region == "" {
+		return AWSCredentials{}, "", fmt.Errorf("no AWS region, set --aws-region or AWS_REGION")
+	}
+
+	if cfg.Profile == "" && getenv("AWS_ACCESS_KEY_ID") != "" {
+		creds := AWSCredentials{
+			AccessKeyID:     getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    getenv("AWS_SESSION_TOKEN"),
+		}
+		if creds.SecretAccessKey == "" {
+			return AWSCredentials{}, "", fmt.Errorf("AWS_ACCESS_KEY_ID is set without AWS_SECRET_ACCESS_KEY")
+		}
+		return creds, region, nil
+	}
+	for _, s := range []map[string]string{credentialsSections[profile], config} {
+		if s["aws_access_key_id"] == "" {
+			continue
+		}
+		creds := AWSCredentials{
+			AccessKeyID:     s["aws_access_key_id"],
+			SecretAccessKey: s["aws_secret_access_key"],
+			SessionToken:    s["aws_session_token"],
+		}
+		if creds.SecretAccessKey == "" {
+			return AWSCredentials{}, "", fmt.Errorf("the AWS profile %q has no aws_secret_access_key", profile)
+		}
+		return creds, region, nil
+	}
+	return AWSCredentials{}, "", fmt.Errorf("no AWS credentials in the environment or the profile %q of %s and %s", profile, credentialsFile, configFile)
+}
+
+// readINI reads the sections of an AWS shared credentials or config file.
+// A missing file has no sections.
+func readINI(path string) (map[string]map[string]string, error) {
+	sections := make(map[string]map[string]string)
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return sections, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var section map[string]string
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+		case line[0] == '[' && line[len(line)-1] == ']':
+			name := strings.Join(strings.Fields(line[1:len(line)-1]), " ")
+			if sections[name] == nil {
+				sections[name] = make(map[string]string)
+			}
+			section = sections[name]
+		case section != nil:
+			if i := strings.Index(line, "="); i > 0 {
+				section[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+			}
+		}
+	}
+	return sections, sc.Err()
+}
+
+// sigV4Transport signs the requests with AWS Signature Version 4.
+type sigV4Transport struct {
+	next    http.RoundTripper
+	creds   AWSCredentials
+	region  string
+	service string
+	now     func() time.Time
+}
+
+func newSigV4Transport(next http.RoundTripper, creds AWSCredentials, region, service string) *sigV4Transport {
+	return &sigV4Transport{
+		next:    next,
+		creds:   creds,
+		region:  region,
+		service: firstNonEmpty(service, DefaultAWSService),
+		now:     time.Now,
+	}
+}
+
+// RoundTrip signs a copy of req and sends it.
+func (t *sigV4Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		b, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+	r := req.Clone(req.Context())
+	if body != nil {
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	// OpenSearch Serverless requires the hash of the payload.
+	payload := sha256.Sum256(body)
+	r.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payload[:]))
+	t.sign(r, body)
+	return t.next.RoundTrip(r)
+}
+
+// sign sets the X-Amz-Date, X-Amz-Security-Token and Authorization headers
+// of req with body as its payload.
+func (t *sigV4Transport) sign(req *http.Request, body []byte) {
+	now := t.now().UTC()
+	date := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", date)
+	if t.creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", t.creds.SessionToken)
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	// The host, content type and x-amz-* headers are signed.
+	headers := map[string]string{"host": host}
+	for k, v := range req.Header {
+		if k = strings.ToLower(k); k == "content-type" || strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.Join(strings.Fields(strings.Join(v, ",")), " ")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", k, headers[k])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	payload := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsEscape(req.URL.EscapedPath(), false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payload[:]),
+	}, "\n")
+
+	scope := strings.Join([]string{date[:8], t.region, t.service, "aws4_request"}, "/")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", date, scope, hex.EncodeToString(hash[:])}, "\n")
+
+	key := []byte("AWS4" + t.creds.SecretAccessKey)
+	for _, s := range []string{date[:8], t.region, t.service, "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", t.creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// canonicalQuery returns the query parameters sorted by name and value and
+// escaped.
+func canonicalQuery(query map[string][]string) string {
+	var params [][2]string
+	for k, vs := range query {
+		for _, v := range vs {
+			params = append(params, [2]string{awsEscape(k, true), awsEscape(v, true)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+		return params[i][1] < params[j][1]
+	})
+	s := make([]string, len(params))
+	for i, p := range params {
+		s[i] = p[0] + "=" + p[1]
+	}
+	return strings.Join(s, "&")
+}
+
+// awsEscape percent-encodes s except the unreserved characters and, unless
+// encodeSlash, the slashes. An empty path is "/".
+func awsEscape(s string, encodeSlash bool) string {
+	if s == "" && !encodeSlash {
+		return "/"
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
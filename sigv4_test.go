@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The requests and signatures of the AWS Signature Version 4 test suite.
+func TestSigV4Sign(t *testing.T) {
+	t.Parallel()
+	type in struct {
+		method, url, contentType, body string
+	}
+	tests := []struct {
+		in   in
+		want string
+	}{
+		{
+			in:   in{method: "GET", url: "https://example.amazonaws.com/"},
+			want: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			in:   in{method: "GET", url: "https://example.amazonaws.com/?Param2=value2&Param1=value1"},
+			want: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			in:   in{method: "POST", url: "https://example.amazonaws.com/"},
+			want: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			in:   in{method: "POST", url: "https://example.amazonaws.com/", contentType: "application/x-www-form-urlencoded", body: "Param1=value1"},
+			want: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			tp := newSigV4Transport(nil, AWSCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}, "us-east-1", "service")
+			tp.now = func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) }
+			req, err := http.NewRequest(tt.in.method, tt.in.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.in.contentType != "" {
+				req.Header.Set("Content-Type", tt.in.contentType)
+			}
+			tp.sign(req, []byte(tt.in.body))
+			if got := req.Header.Get("Authorization"); got != tt.want {
+				t.Fatalf("in: %v got: %v want: %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSigV4Transport(t *testing.T) {
+	t.Parallel()
+	type request struct {
+		path, auth, date, token, hash, body string
+	}
+	tp := newSigV4Transport(http.DefaultTransport, AWSCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", SessionToken: "token"}, "ap-northeast-1", "")
+	tp.now = func() time.Time { return time.Date(2020, 12, 23, 13, 4, 5, 0, time.UTC) }
+
+	got := make(chan request, 1)
+	verified := make(chan bool, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		got <- request{
+			path:  r.URL.EscapedPath(),
+			auth:  r.Header.Get("Authorization"),
+			date:  r.Header.Get("X-Amz-Date"),
+			token: r.Header.Get("X-Amz-Security-Token"),
+			hash:  r.Header.Get("X-Amz-Content-Sha256"),
+			body:  string(b),
+		}
+		// Sign the request as received like the server does.
+		v := r.Clone(r.Context())
+		v.Header.Del("Authorization")
+		tp.sign(v, b)
+		verified <- v.Header.Get("Authorization") == r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	req, err := http.NewRequest("POST", srv.URL+"/log-aws-waf-*/_search", strings.NewReader(`{"size":0}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := (&http.Client{Transport: tp}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	r := <-got
+	want := request{
+		path:  "/log-aws-waf-*/_search",
+		date:  "20201223T130405Z",
+		token: "token",
+		hash:  "8692dd0b13a01d48f9b7f36a046a773b769b06bc0b54eac0413aaf1e162a8acb",
+		body:  `{"size":0}`,
+	}
+	prefix := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20201223/ap-northeast-1/es/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-security-token, Signature="
+	if !strings.HasPrefix(r.auth, prefix) {
+		t.Fatalf("got: %v want: %v...", r.auth, prefix)
+	}
+	if !<-verified {
+		t.Fatalf("the signature does not match the request received")
+	}
+	r.auth = ""
+	if r != want {
+		t.Fatalf("got: %+v want: %+v", r, want)
+	}
+	if req.Header.Get("Authorization") != "" {
+		t.Fatalf("the request is modified: %v", req.Header)
+	}
+}
+
+func TestLoadAWSCredentials(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	credentialsFile := filepath.Join(dir, "credentials")
+	configFile := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(credentialsFile, []byte(`[default]
+aws_access_key_id = AKIDDEFAULT
+aws_secret_access_key = default-secret
+
+# A comment
+[prod]
+aws_access_key_id=AKIDPROD
+aws_secret_access_key=prod-secret
+aws_session_token=prod-token
+
+[broken]
+aws_access_key_id = AKIDBROKEN
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(configFile, []byte(`[default]
+region = us-east-1
+
+[profile prod]
+region = ap-northeast-1
+
+[profile staging]
+region = eu-west-1
+aws_access_key_id = AKIDSTAGING
+aws_secret_access_key = staging-secret
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	type in struct {
+		cfg AWSConfig
+		env map[string]string
+	}
+	type want struct {
+		creds  AWSCredentials
+		region string
+	}
+	files := map[string]string{"AWS_SHARED_CREDENTIALS_FILE": credentialsFile, "AWS_CONFIG_FILE": configFile}
+	with := func(env map[string]string) map[string]string {
+		m := map[string]string{}
+		for k, v := range files {
+			m[k] = v
+		}
+		for k, v := range env {
+			m[k] = v
+		}
+		return m
+	}
+	tests := []struct {
+		in      in
+		want    want
+		wantErr bool
+	}{
+		{in: in{env: with(nil)}, want: want{creds: AWSCredentials{"AKIDDEFAULT", "default-secret", ""}, region: "us-east-1"}, wantErr: false},
+		{in: in{cfg: AWSConfig{Profile: "prod"}, env: with(nil)}, want: want{creds: AWSCredentials{"AKIDPROD", "prod-secret", "prod-token"}, region: "ap-northeast-1"}, wantErr: false},
+		{in: in{env: with(map[string]string{"AWS_PROFILE": "staging"})}, want: want{creds: AWSCredentials{"AKIDSTAGING", "staging-secret", ""}, region: "eu-west-1"}, wantErr: false},
+		{in: in{cfg: AWSConfig{Region: "us-west-2"}, env: with(map[string]string{"AWS_ACCESS_KEY_ID": "AKIDENV", "AWS_SECRET_ACCESS_KEY": "env-secret", "AWS_REGION": "eu-central-1"})}, want: want{creds: AWSCredentials{"AKIDENV", "env-secret", ""}, region: "us-west-2"}, wantErr: false},
+		{in: in{env: with(map[string]string{"AWS_ACCESS_KEY_ID": "AKIDENV", "AWS_SECRET_ACCESS_KEY": "env-secret", "AWS_DEFAULT_REGION": "eu-central-1"})}, want: want{creds: AWSCredentials{"AKIDENV", "env-secret", ""}, region: "eu-central-1"}, wantErr: false},
+		{in: in{cfg: AWSConfig{Profile: "prod"}, env: with(map[string]string{"AWS_ACCESS_KEY_ID": "AKIDENV", "AWS_SECRET_ACCESS_KEY": "env-secret"})}, want: want{creds: AWSCredentials{"AKIDPROD", "prod-secret", "prod-token"}, region: "ap-northeast-1"}, wantErr: false},
+		{in: in{env: with(map[string]string{"AWS_ACCESS_KEY_ID": "AKIDENV"})}, want: want{}, wantErr: true},
+		{in: in{cfg: AWSConfig{Profile: "broken", Region: "us-east-1"}, env: with(nil)}, want: want{}, wantErr: true},
+		{in: in{cfg: AWSConfig{Profile: "missing", Region: "us-east-1"}, env: with(nil)}, want: want{}, wantErr: true},
+		{in: in{env: map[string]string{"AWS_SHARED_CREDENTIALS_FILE": credentialsFile, "AWS_CONFIG_FILE": filepath.Join(dir, "none")}}, want: want{}, wantErr: true},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			creds, region, err := loadAWSCredentials(tt.in.cfg, func(k string) string { return tt.in.env[k] })
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("in: %v err: %v wantErr: %v", tt.in, err, tt.wantErr)
+			}
+			if got := (want{creds: creds, region: region}); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("in: %v got: %v want: %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
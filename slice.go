@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// paginate fetches the pages of pg until every hit has been seen, calling fn
+// for every hit and onPage after every page.
+// It returns the sum of the took times of the pages in milliseconds.
+func paginate(ctx context.Context, pg Paginator, fn func(hit []byte) error, onPage func(p *Page) error) (int64, error) {
+	var (
+		page              Page
+		total, took, seen int64
+	)
+	for {
+		page = Page{Total: total}
+		if err := pg.Next(ctx, &page, fn); err != nil {
+			return took, err
+		}
+		total = page.Total
+		took += page.Took
+		seen += page.Hits
+		log.Debug().Msgf("total hits: %v", total)
+		log.Debug().Msgf("hits: %v", page.Hits)
+		if err := onPage(&page); err != nil {
+			return took, err
+		}
+		if page.Hits == 0 || seen >= total {
+			return took, nil
+		}
+	}
+}
+
+// sliceOutput merges the documents of concurrently fetched slices into a
+// single Formatter.
+//
+// Unordered documents are written as they arrive. Ordered documents of the
+// first slice are written as they arrive while the other slices are spooled
+// to temporary files, which Flush writes in slice order.
+type sliceOutput struct {
+	mu      sync.Mutex
+	f       Formatter
+	ordered bool
+	files   []*os.File
+	bufs    []*bufio.Writer
+}
+
+func newSliceOutput(f Formatter, n int, ordered bool) (*sliceOutput, error) {
+	o := &sliceOutput{f: f, ordered: ordered}
+	if !ordered {
+		return o, nil
+	}
+	o.files = make([]*os.File, n)
+	o.bufs = make([]*bufio.Writer, n)
+	for i := 1; i < n; i++ {
+		file, err := ioutil.TempFile("", "escli-slice-*")
+		if err != nil {
+			o.Close()
+			return nil, err
+		}
+		o.files[i] = file
+		o.bufs[i] = bufio.NewWriter(file)
+	}
+	return o, nil
+}
+
+// Write writes the compact JSON document doc of slice i.
+func (o *sliceOutput) Write(i int, doc []byte) error {
+	if !o.ordered {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		return o.f.Write(doc)
+	}
+	if i == 0 {
+		return o.f.Write(doc)
+	}
+	if _, err := o.bufs[i].Write(doc); err != nil {
+		return err
+	}
+	return o.bufs[i].WriteByte('\n')
+}
+
+// Flush writes the spooled documents in slice order.
+func (o *sliceOutput) Flush() error {
+	for i, file := range o.files {
+		if file == nil {
+			continue
+		}
+		if err := o.bufs[i].Flush(); err != nil {
+			return err
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r := bufio.NewReader(file)
+		for {
+			doc, err := r.ReadBytes('\n')
+			if len(doc) > 1 {
+				if err := o.f.Write(doc[:len(doc)-1]); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close removes the temporary files.
+func (o *sliceOutput) Close() error {
+	for _, file := range o.files {
+		if file == nil {
+			continue
+		}
+		file.Close()
+		if err := os.Remove(file.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestSliceOutput(t *testing.T) {
+	t.Parallel()
+	type in struct {
+		slice int
+		doc   string
+	}
+	docs := []in{{2, `{"_id":"c"}`}, {0, `{"_id":"a"}`}, {1, `{"_id":"b"}`}, {2, `{"_id":"d"}`}, {0, `{"_id":"e"}`}}
+	tests := []struct {
+		ordered bool
+		want    string
+	}{
+		{ordered: false, want: "{\"_id\":\"c\"}\n{\"_id\":\"a\"}\n{\"_id\":\"b\"}\n{\"_id\":\"d\"}\n{\"_id\":\"e\"}\n"},
+		{ordered: true, want: "{\"_id\":\"a\"}\n{\"_id\":\"e\"}\n{\"_id\":\"b\"}\n{\"_id\":\"c\"}\n{\"_id\":\"d\"}\n"},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			var b bytes.Buffer
+			o, err := newSliceOutput(&ndjsonFormatter{w: &b}, 3, tt.ordered)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer o.Close()
+			for _, d := range docs {
+				if err := o.Write(d.slice, []byte(d.doc)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := o.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if got := b.String(); got != tt.want {
+				t.Fatalf("ordered: %v got: %q want: %q", tt.ordered, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+)
+
+// queryVars returns the template variables of the --vars-file and --var flags.
+// --var takes precedence over --vars-file.
+func queryVars(c *cli.Context) (map[string]interface{}, error) {
+	vars := make(map[string]interface{})
+	if name := c.String("vars-file"); name != "" {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		// JSON is read as YAML as well.
+		var v map[string]interface{}
+		if err := yaml.Unmarshal(b, &v); err != nil {
+			return nil, fmt.Errorf("Error parsing the vars file %s: %s", name, err)
+		}
+		for k, v := range v {
+			vars[k] = normalizeYAML(v)
+		}
+	}
+	kv, err := parseVars(c.StringSlice("var"))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range kv {
+		vars[k] = v
+	}
+	return vars, nil
+}
+
+// parseVars parses key=value pairs.
+func parseVars(pairs []string) (map[string]interface{}, error) {
+	vars := make(map[string]interface{}, len(pairs))
+	for _, p := range pairs {
+		i := strings.Index(p, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("invalid --var %q: expected key=value", p)
+		}
+		vars[p[:i]] = p[i+1:]
+	}
+	return vars, nil
+}
+
+// normalizeYAML converts the maps decoded by yaml into maps with string keys,
+// so that they can be encoded to JSON.
+func normalizeYAML(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, vv := range v {
+			m[fmt.Sprint(k)] = normalizeYAML(vv)
+		}
+		return m
+	case []interface{}:
+		for i, vv := range v {
+			v[i] = normalizeYAML(vv)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// renderQuery executes the query template text with vars.
+//
+// Besides the variables, the template can use the following functions:
+//
+//	json         encodes a value as JSON, e.g. {{ .ip | json }}
+//	since, until the time range formatted in the time format
+//	timeField    the field filtered by the time range
+//	timeFormat   the date format of the time range
+//	now          the current time
+//	formatTime   formats a time with a Go layout, e.g. {{ now | formatTime "2006.01.02" }}
+//	epochMillis  a time in milliseconds since the Unix epoch
+func renderQuery(name string, text []byte, vars map[string]interface{}, tf *TimeFilter) ([]byte, error) {
+	funcs := template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+		"since":      func() string { return tf.format(tf.Since) },
+		"until":      func() string { return tf.format(tf.Until) },
+		"timeField":  func() string { return tf.Field },
+		"timeFormat": func() string { return tf.Format },
+		"now":        time.Now,
+		"formatTime": func(layout string, t time.Time) string { return t.Format(layout) },
+		"epochMillis": func(t time.Time) int64 {
+			return t.UnixNano() / int64(time.Millisecond)
+		},
+	}
+	tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, vars); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
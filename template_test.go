@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseVars(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in      []string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{in: nil, want: map[string]interface{}{}},
+		{in: []string{"ip=192.0.2.1", "q=a=b", "empty="}, want: map[string]interface{}{"ip": "192.0.2.1", "q": "a=b", "empty": ""}},
+		{in: []string{"ip"}, wantErr: true},
+		{in: []string{"=192.0.2.1"}, wantErr: true},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			got, err := parseVars(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("in: %v err: %v wantErr: %v", tt.in, err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("in: %v got: %v want: %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderQuery(t *testing.T) {
+	t.Parallel()
+	tf := &TimeFilter{
+		Field:  "@timestamp",
+		Format: "epoch_millis",
+		Since:  time.Date(2020, 12, 23, 13, 4, 5, 0, time.UTC),
+		Until:  time.Date(2020, 12, 23, 14, 15, 16, 0, time.UTC),
+	}
+	vars := map[string]interface{}{
+		"ip":   "192.0.2.1",
+		"host": `www."example".com`,
+		"ids":  []interface{}{"a", "b"},
+	}
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: `{"query":{"term":{"httpRequest.clientIp":"{{ .ip }}"}}}`, want: `{"query":{"term":{"httpRequest.clientIp":"192.0.2.1"}}}`},
+		{in: `{"query":{"term":{"host":{{ .host | json }}}}}`, want: `{"query":{"term":{"host":"www.\"example\".com"}}}`},
+		{in: `{"query":{"terms":{"_id":{{ json .ids }}}}}`, want: `{"query":{"terms":{"_id":["a","b"]}}}`},
+		{in: `{"query":{"range":{"{{ timeField }}":{"gte":{{ since }},"lte":{{ until }}}}}}`, want: `{"query":{"range":{"@timestamp":{"gte":1608728645000,"lte":1608732916000}}}}`},
+		{in: `{{ .missing }}`, wantErr: true},
+		{in: `{{ .ip `, wantErr: true},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			got, err := renderQuery("test", []byte(tt.in), vars, tf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("in: %v err: %v wantErr: %v", tt.in, err, tt.wantErr)
+			}
+			if err == nil && string(got) != tt.want {
+				t.Fatalf("in: %v got: %s want: %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
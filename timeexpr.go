@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// timeLayouts are the absolute time layouts accepted by parseTime. Layouts
+// without a zone are interpreted in the location passed to parseTime.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+var (
+	nowExpr  = regexp.MustCompile(`^now(?:([+-])(.+))?$`)
+	unitExpr = regexp.MustCompile(`^(\d+)([dw])$`)
+)
+
+// parseTime resolves the time expression s relative to now in loc.
+//
+// The following expressions are accepted:
+//
+//	now, now-15m, now+1h     relative to the current time
+//	1h, 30m, 2d, 1w          the duration ago
+//	today, yesterday, tomorrow
+//	2006-01-02, 2006-01-02 15:04:05, RFC 3339 with or without an offset
+//	1609459200, 1609459200000  Unix epoch in seconds or milliseconds
+func parseTime(s string, now time.Time, loc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	now = now.In(loc)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	switch strings.ToLower(s) {
+	case "today":
+		return midnight, nil
+	case "yesterday":
+		return midnight.AddDate(0, 0, -1), nil
+	case "tomorrow":
+		return midnight.AddDate(0, 0, 1), nil
+	}
+	if m := nowExpr.FindStringSubmatch(strings.ToLower(s)); m != nil {
+		if m[1] == "" {
+			return now, nil
+		}
+		d, err := parseDuration(m[2])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %q: %s", s, err)
+		}
+		if m[1] == "-" {
+			d = -d
+		}
+		return now.Add(d), nil
+	}
+	if isDigits(s) {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %q: %s", s, err)
+		}
+		// More than 10 digits cannot be seconds before the year 2286.
+		if len(s) > 10 {
+			return time.Unix(0, n*int64(time.Millisecond)).In(loc), nil
+		}
+		return time.Unix(n, 0).In(loc), nil
+	}
+	if d, err := parseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+// timeRange resolves the --since and --until flags in the --timezone location.
+func timeRange(c *cli.Context) (since, until time.Time, err error) {
+	loc, err := time.LoadLocation(c.String("timezone"))
+	if err != nil {
+		return since, until, fmt.Errorf("invalid time zone %q: %s", c.String("timezone"), err)
+	}
+	now := time.Now()
+	if since, err = parseTime(c.String("since"), now, loc); err != nil {
+		return since, until, err
+	}
+	if until, err = parseTime(c.String("until"), now, loc); err != nil {
+		return since, until, err
+	}
+	if since.After(until) {
+		return since, until, fmt.Errorf("--since %s is after --until %s", since.Format(time.RFC3339), until.Format(time.RFC3339))
+	}
+	return since, until, nil
+}
+
+// localZoneName returns the IANA name of the local time zone, taken from TZ
+// or the /etc/localtime link, or "" when it is unknown.
+func localZoneName(lookupEnv func(string) (string, bool), readlink func(string) (string, error)) string {
+	name, ok := lookupEnv("TZ")
+	switch {
+	case ok && name == "":
+		return "UTC"
+	case !ok:
+		link, err := readlink("/etc/localtime")
+		if err != nil {
+			return ""
+		}
+		name = link
+	}
+	name = strings.TrimPrefix(name, ":")
+	if i := strings.LastIndex(name, "zoneinfo/"); i >= 0 {
+		name = name[i+len("zoneinfo/"):]
+	}
+	if strings.HasPrefix(name, "/") {
+		return ""
+	}
+	if _, err := time.LoadLocation(name); err != nil {
+		return ""
+	}
+	return name
+}
+
+// parseDuration is time.ParseDuration accepting also days and weeks.
+func parseDuration(s string) (time.Duration, error) {
+	if m := unitExpr.FindStringSubmatch(s); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return 0, err
+		}
+		day := 24 * time.Hour
+		if m[2] == "w" {
+			return time.Duration(n) * 7 * day, nil
+		}
+		return time.Duration(n) * day, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	t.Parallel()
+	tokyo := time.FixedZone("JST", 9*60*60)
+	now := time.Date(2020, 12, 23, 13, 4, 5, 0, time.UTC)
+	tests := []struct {
+		in      string
+		loc     *time.Location
+		want    time.Time
+		wantErr bool
+	}{
+		{in: "now", loc: time.UTC, want: now},
+		{in: "now-15m", loc: time.UTC, want: now.Add(-15 * time.Minute)},
+		{in: "now+1h", loc: time.UTC, want: now.Add(time.Hour)},
+		{in: "now-2d", loc: time.UTC, want: now.Add(-48 * time.Hour)},
+		{in: "1h", loc: time.UTC, want: now.Add(-time.Hour)},
+		{in: "1w", loc: time.UTC, want: now.Add(-7 * 24 * time.Hour)},
+		{in: "today", loc: time.UTC, want: time.Date(2020, 12, 23, 0, 0, 0, 0, time.UTC)},
+		{in: "yesterday", loc: tokyo, want: time.Date(2020, 12, 22, 0, 0, 0, 0, tokyo)},
+		{in: "tomorrow", loc: time.UTC, want: time.Date(2020, 12, 24, 0, 0, 0, 0, time.UTC)},
+		{in: "2024-05-01", loc: tokyo, want: time.Date(2024, 5, 1, 0, 0, 0, 0, tokyo)},
+		{in: "2020-12-23 13:04:05", loc: tokyo, want: time.Date(2020, 12, 23, 13, 4, 5, 0, tokyo)},
+		{in: "2020-12-23T13:04:05+09:00", loc: time.UTC, want: time.Date(2020, 12, 23, 4, 4, 5, 0, time.UTC)},
+		{in: "2020-12-23T13:04:05.123Z", loc: tokyo, want: time.Date(2020, 12, 23, 13, 4, 5, 123000000, time.UTC)},
+		{in: "1608728645", loc: time.UTC, want: time.Date(2020, 12, 23, 13, 4, 5, 0, time.UTC)},
+		{in: "1608728645123", loc: time.UTC, want: time.Date(2020, 12, 23, 13, 4, 5, 123000000, time.UTC)},
+		{in: "now-", loc: time.UTC, wantErr: true},
+		{in: "last week", loc: time.UTC, wantErr: true},
+		{in: "2020-13-01", loc: time.UTC, wantErr: true},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			got, err := parseTime(tt.in, now, tt.loc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("in: %v err: %v wantErr: %v", tt.in, err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Fatalf("in: %v got: %v want: %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalZoneName(t *testing.T) {
+	t.Parallel()
+	type in struct {
+		tz   *string
+		link string
+	}
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		in   in
+		want string
+	}{
+		{in: in{tz: str("Asia/Tokyo")}, want: "Asia/Tokyo"},
+		{in: in{tz: str(":Europe/Paris")}, want: "Europe/Paris"},
+		{in: in{tz: str("/usr/share/zoneinfo/America/New_York")}, want: "America/New_York"},
+		{in: in{tz: str("")}, want: "UTC"},
+		{in: in{tz: str("JST-9")}, want: ""},
+		{in: in{tz: str("/etc/mytz")}, want: ""},
+		{in: in{link: "/usr/share/zoneinfo/Asia/Tokyo"}, want: "Asia/Tokyo"},
+		{in: in{link: "../usr/share/zoneinfo/Etc/UTC"}, want: "Etc/UTC"},
+		{in: in{link: "/var/db/timezone/zoneinfo/Europe/Berlin"}, want: "Europe/Berlin"},
+		{in: in{}, want: ""},
+	}
+	for i, tt := range tests {
+		i, tt := i, tt
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			lookupEnv := func(string) (string, bool) {
+				if tt.in.tz == nil {
+					return "", false
+				}
+				return *tt.in.tz, true
+			}
+			readlink := func(string) (string, error) {
+				if tt.in.link == "" {
+					return "", os.ErrNotExist
+				}
+				return tt.in.link, nil
+			}
+			if got := localZoneName(lookupEnv, readlink); got != tt.want {
+				t.Fatalf("in: %v got: %v want: %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
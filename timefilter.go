@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// DefaultTimeField is the field filtered by the time range when neither
+	// --time-field nor the current context specifies one.
+	DefaultTimeField = "@timestamp"
+	// DefaultTimeFormat is the date format of the time range when neither
+	// --time-format nor the current context specifies one.
+	DefaultTimeFormat = "strict_date_optional_time"
+)
+
+// TimeFilter is the time range filter of a query.
+type TimeFilter struct {
+	Field    string
+	Format   string
+	Since    time.Time
+	Until    time.Time
+	Disabled bool
+}
+
+// timeFilter returns the TimeFilter for since and until configured by the
+// flags and the current context.
+func timeFilter(c *cli.Context, since, until time.Time) (*TimeFilter, error) {
+	ctx, err := currentContext(c)
+	if err != nil {
+		return nil, err
+	}
+	tf := &TimeFilter{
+		Field:    firstNonEmpty(c.String("time-field"), ctx.TimeField, DefaultTimeField),
+		Format:   firstNonEmpty(c.String("time-format"), ctx.TimeFormat, DefaultTimeFormat),
+		Since:    since,
+		Until:    until,
+		Disabled: c.Bool("no-time-filter"),
+	}
+	return tf, nil
+}
+
+// Clause returns the range query clause, or a match_all clause when the
+// filter is disabled.
+func (f *TimeFilter) Clause() ([]byte, error) {
+	if f.Disabled {
+		return []byte(`{"match_all":{}}`), nil
+	}
+	return json.Marshal(map[string]interface{}{
+		"range": map[string]interface{}{
+			f.Field: map[string]string{
+				"gte":    f.format(f.Since),
+				"lte":    f.format(f.Until),
+				"format": f.Format,
+			},
+		},
+	})
+}
+
+// format formats t as expected by the date format of the filter.
+func (f *TimeFilter) format(t time.Time) string {
+	switch f.Format {
+	case "epoch_millis":
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+	case "epoch_second":
+		return strconv.FormatInt(t.Unix(), 10)
+	default:
+		return t.Format(time.RFC3339Nano)
+	}
+}
+
+// firstNonEmpty returns the first of values that is not empty.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 
 	"github.com/elastic/go-elasticsearch/v8"
@@ -13,36 +12,39 @@ var versionCommand = &cli.Command{
 	Name:   "version",
 	Usage:  "Shows the version information",
 	Action: versionAction,
+	Flags: []cli.Flag{
+		outputFlag("text", "text"),
+		columnsFlag,
+	},
 }
 
 func versionAction(c *cli.Context) error {
 	w := c.App.Writer
+	if format := c.String("output"); format != "text" {
+		info, err := getInfo(c)
+		if err != nil {
+			return err
+		}
+		var server struct {
+			Version string `json:"version"`
+		}
+		server.Version = info.Version.Number
+		return writeDocument(w, format, c.StringSlice("columns"), struct {
+			Client ClientInfo  `json:"client"`
+			Server interface{} `json:"server"`
+		}{newClientInfo(), server})
+	}
+
 	fmt.Fprintf(w, "Client:\n")
 	fmt.Fprintf(w, " Version:\t%s\n", version.Version)
 	fmt.Fprintf(w, " Git commit:\t%s\n", version.Revision)
 	fmt.Fprintf(w, "Elasticsearch:\n")
 	fmt.Fprintf(w, " Version:\t%s\n", elasticsearch.Version)
 
-	es, err := newClient(c)
-	if err != nil {
-		return err
-	}
-	res, err := es.Info()
+	info, err := getInfo(c)
 	if err != nil {
 		return err
 	}
-	defer res.Body.Close()
-
-	// Check response status
-	if res.IsError() {
-		return fmt.Errorf("%s", res.String())
-	}
-
-	// Deserialize the response into a map.
-	var info Info
-	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
-		return err
-	}
 	fmt.Fprintf(w, "\n")
 	fmt.Fprintf(w, "Server:\n")
 	fmt.Fprintf(w, " Version:\t%s\n", info.Version.Number)